@@ -0,0 +1,217 @@
+package in
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/metrics"
+	"github.com/qjw/proxy/utils"
+)
+
+// 命令行参数，常用字段显式指定时覆盖配置文件和环境变量
+type cliFlags struct {
+	fs        *flag.FlagSet
+	config    *string
+	checkOnly *bool
+	server    *string
+	token     *string
+	metrics   *string
+	logLevel  *string
+	stdio     *bool
+	topic     *string
+	target    *string
+}
+
+func newFlags(name string) *cliFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &cliFlags{
+		fs:        fs,
+		config:    fs.String("config", "", "配置文件"),
+		checkOnly: fs.Bool("check-config", false, "只校验配置文件，有错误时以非0退出"),
+		server:    fs.String("server", "", "proxy的地址host:port，多个用逗号分隔，覆盖所有network"),
+		token:     fs.String("token", "", "认证密钥，覆盖所有network"),
+		metrics:   fs.String("metrics", "", "监控指标的监听地址"),
+		logLevel:  fs.String("log-level", "", "日志级别debug/info/warn/error"),
+		stdio:     fs.Bool("stdio", false, "不监听端口，把标准输入输出接到topic的隧道上（ssh的ProxyCommand）"),
+		topic:     fs.String("topic", "", "--stdio使用的topic"),
+		target:    fs.String("target", "", "--stdio带给out的目标地址host:port（exit模式）"),
+	}
+}
+
+// 命令行中是否指定了该参数
+func (this *cliFlags) isSet(name string) bool {
+	set := false
+	this.fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func (this *cliFlags) apply(conf *Config) error {
+	var err error
+	this.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			servers := make([]string, 0)
+			for _, addr := range strings.Split(*this.server, ",") {
+				if addr = strings.TrimSpace(addr); addr == "" {
+					continue
+				}
+				if _, _, e := utils.SplitHostPort(addr); e != nil {
+					err = fmt.Errorf("invalid --server: %s", e.Error())
+					return
+				}
+				servers = append(servers, addr)
+			}
+			if len(servers) == 0 {
+				err = fmt.Errorf("invalid --server: empty")
+				return
+			}
+			for _, v := range conf.Networks {
+				if len(servers) == 1 {
+					v.ServerHost, v.ServerPort, _ = utils.SplitHostPort(servers[0])
+					v.Servers = nil
+				} else {
+					v.Servers = servers
+				}
+			}
+		case "token":
+			for _, v := range conf.Networks {
+				v.Token = *this.token
+			}
+		case "metrics":
+			conf.Metrics = *this.metrics
+		case "log-level":
+			if conf.Log == nil {
+				conf.Log = logs.DefaultConfig()
+			}
+			conf.Log.Level = *this.logLevel
+		}
+	})
+	return err
+}
+
+// proxy in子命令，返回进程的退出码
+func Main(name string, args []string) int {
+	f := newFlags(name)
+	if err := f.fs.Parse(args); err != nil {
+		return 2
+	}
+	load := func() (*Config, error) {
+		return LoadConfig(*f.config, f.apply)
+	}
+	if *f.stdio {
+		return mainStdio(f, load)
+	}
+	if *f.topic != "" || *f.target != "" {
+		fmt.Fprintln(os.Stderr, "--topic and --target require --stdio")
+		return ExitUsage
+	}
+
+	conf, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *f.checkOnly {
+		fmt.Println("config ok")
+		return 0
+	}
+	if err := logs.Setup(conf.Log, "in"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logs.Info("loaded config", "config", utils.RedactConfig(conf))
+
+	s, err := New(conf)
+	if err != nil {
+		logs.Error("create in failed", "error", err)
+		return 1
+	}
+	logs.Info("starting the server", "version", utils.Version)
+	utils.RandomSeed()
+	if err := s.Start(); err != nil {
+		logs.Error("listen failed", "error", err)
+		return 1
+	}
+	if conf.Metrics != "" {
+		go metrics.Serve(conf.Metrics)
+	}
+
+	// 信号 和谐的退出，SIGHUP重新加载配置
+	exitMng := utils.NewExitManager()
+	exitMng.OnReload(func() {
+		if *f.config == "" {
+			logs.Error("reload config failed", "error", "started without config file")
+			return
+		}
+		conf, err := load()
+		if err == nil {
+			old := s.Config().Log
+			if err = s.Reload(conf); err == nil {
+				err = logs.Update(old, conf.Log, "in")
+			}
+		}
+		if err != nil {
+			logs.Error("reload config failed", "error", err)
+		}
+	})
+	go exitMng.Run(s.Shutdown)
+
+	// 等待结束
+	s.Wait()
+	return 0
+}
+
+// proxy in --stdio，标准输出用于数据，日志和访问日志只能输出到stderr等
+func mainStdio(f *cliFlags, load func() (*Config, error)) int {
+	if *f.topic != "" {
+		if err := utils.CheckTopic(*f.topic, false); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid --topic:", err)
+			return ExitUsage
+		}
+	}
+	if *f.target != "" {
+		if err := utils.CheckTarget(*f.target); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid --target:", err)
+			return ExitUsage
+		}
+	}
+
+	conf, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	if conf.Log == nil {
+		conf.Log = logs.DefaultConfig()
+	}
+	if conf.Log.Output == logs.OutputStdout {
+		conf.Log.Output = logs.OutputStderr
+	}
+	if !f.isSet("log-level") {
+		// 作为ProxyCommand时不打印每个连接的日志
+		conf.Log.Level = "warn"
+	}
+	if conf.Access != nil && conf.Access.Output == logs.OutputStdout {
+		conf.Access.Output = logs.OutputStderr
+	}
+	if err := logs.Setup(conf.Log, "in"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	access, err := logs.OpenAccessLog(conf.Access, "in")
+	if err != nil {
+		logs.Error("open access log failed", "error", err)
+		return ExitError
+	}
+	defer access.Close()
+
+	utils.RandomSeed()
+	return Stdio(conf.StdioNetwork(*f.topic), *f.target, access)
+}
@@ -1,22 +1,41 @@
-package main
+package in
 
 import (
+	"github.com/qjw/proxy/logs"
 	"github.com/qjw/proxy/utils"
 )
 
 type Network struct {
-	ServerHost string `json:"server_host" binding:"required"` // 服务器域名/IP
-	ServerPort uint16 `json:"server_port" binding:"required"` // 服务器端口
-	Bind       string `json:"bind" binding:"required"`        // 绑定的本地主机
-	Port       uint16 `json:"port" binding:"required"`        // 绑定的本地端口
-	Topic      string `json:"topic"`                          // 请求类别
+	ServerHost string           `json:"server_host"`             // 服务器域名/IP
+	ServerPort uint16           `json:"server_port"`             // 服务器端口
+	Servers    []string         `json:"servers"`                 // 多个服务器(host:port)，配置之后忽略server_host和server_port
+	Strategy   string           `json:"strategy"`                // 多个服务器的选择策略：failover（默认）、random、latency
+	Bind       string           `json:"bind" binding:"required"` // 绑定的本地主机
+	Port       uint16           `json:"port" binding:"required"` // 绑定的本地端口
+	Topic      string           `json:"topic"`                   // 请求类别
+	TLS        *utils.TLSConfig `json:"tls"`                     // 证书配置，为空则使用明文
+	Token      string           `json:"token"`                   // 认证密钥，和proxy上该Topic的token一致
+	Mux        bool             `json:"mux"`                     // 所有会话复用一个到proxy的连接
+	Compress   string           `json:"compress"`                // 数据压缩算法(deflate)，为空不压缩
+	Mode       string           `json:"mode"`                    // 本地端口的协议：tcp（默认）直接转发，socks5/http按照目标地址选择topic
+	Routes     []*Route         `json:"routes"`                  // socks5/http模式下目标地址到topic的规则
 }
 
+// 本地端口的协议
+const (
+	ModeTCP    = "tcp"
+	ModeSocks5 = "socks5"
+	ModeHTTP   = "http" // HTTP代理的CONNECT方法
+)
+
 type Config struct {
-	Networks []*Network `json:"networks"`
+	Networks []*Network         `json:"networks"`
+	Metrics  string             `json:"metrics"`    // 监控指标(/metrics)的监听地址，为空不开启
+	Log      *logs.Config       `json:"log"`        // 日志配置
+	Access   *logs.AccessConfig `json:"access_log"` // 访问日志，为空不开启
 }
 
-func defaultConfig() *Config {
+func DefaultConfig() *Config {
 	return &Config{
 		Networks: []*Network{
 			{
@@ -27,18 +46,123 @@ func defaultConfig() *Config {
 				Topic:      utils.DftType,
 			},
 		},
+		Log: logs.DefaultConfig(),
+	}
+}
+
+// 依次使用默认值、配置文件（path为空则跳过）、环境变量(IN_*)和override（例如命令行参数，可以为空），最后校验
+func LoadConfig(path string, override func(conf *Config) error) (*Config, error) {
+	conf := DefaultConfig()
+	if path != "" {
+		// 默认的network只在配置文件没有networks时使用，否则networks[0]会继承默认的字段
+		defaults := conf.Networks
+		conf.Networks = nil
+		if err := utils.ConfToStruct(path, conf); err != nil {
+			return nil, err
+		}
+		if conf.Networks == nil {
+			conf.Networks = defaults
+		}
 	}
+	if err := utils.EnvOverride("IN", conf); err != nil {
+		return nil, err
+	}
+	if override != nil {
+		if err := override(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
 }
 
-func parseConfig(path string) *Config {
-	conf := defaultConfig()
-	if err := utils.JsonConfToStruct(path, conf); err != nil {
-		panic(err)
+// 校验配置，返回所有的错误（utils.ConfigErrors）
+func (this *Config) Validate() error {
+	v := utils.NewValidator()
+	v.Required("", this)
+	if len(this.Networks) < 1 || len(this.Networks) > 16 {
+		v.Errorf("networks", "network count must be in 1-16, got %d", len(this.Networks))
 	}
 
-	//	if err := kelly.Validate(conf); err != nil {
-	//		panic(err)
-	//	}
+	addrs := make(map[string]int)
+	for i, n := range this.Networks {
+		path := utils.IndexPath("networks", i)
+		if n == nil {
+			v.Errorf(path, "null network")
+			continue
+		}
+		if len(n.Servers) == 0 {
+			if n.ServerHost == "" {
+				v.Errorf(utils.JoinPath(path, "server_host"), "required")
+			}
+			if n.ServerPort == 0 {
+				v.Errorf(utils.JoinPath(path, "server_port"), "required")
+			}
+		}
+		v.Host(utils.JoinPath(path, "server_host"), n.ServerHost)
+		servers := make(map[string]bool)
+		for j, addr := range n.Servers {
+			spath := utils.IndexPath(utils.JoinPath(path, "servers"), j)
+			if host, _, err := utils.SplitHostPort(addr); err != nil || host == "" {
+				v.Errorf(spath, "invalid address [%s], expect host:port", addr)
+				continue
+			}
+			v.Addr(spath, addr)
+			if servers[addr] {
+				v.Errorf(spath, "duplicate server %s", addr)
+			}
+			servers[addr] = true
+		}
+		if n.Strategy != "" {
+			v.OneOf(utils.JoinPath(path, "strategy"), n.Strategy, StrategyFailover, StrategyRandom, StrategyLatency)
+		}
+		v.Host(utils.JoinPath(path, "bind"), n.Bind)
+		v.Topic(utils.JoinPath(path, "topic"), n.Topic, false)
+		v.TLS(utils.JoinPath(path, "tls"), n.TLS, false)
+		if n.Compress != "" {
+			v.OneOf(utils.JoinPath(path, "compress"), n.Compress, utils.CompressDeflate)
+		}
+		if n.Mode != "" {
+			v.OneOf(utils.JoinPath(path, "mode"), n.Mode, ModeTCP, ModeSocks5, ModeHTTP)
+		}
+		if len(n.Routes) > 0 && n.Mode != ModeSocks5 && n.Mode != ModeHTTP {
+			v.Errorf(utils.JoinPath(path, "routes"), "only available in mode %s/%s", ModeSocks5, ModeHTTP)
+		}
+		for j, r := range n.Routes {
+			rpath := utils.IndexPath(utils.JoinPath(path, "routes"), j)
+			if r == nil {
+				v.Errorf(rpath, "null route")
+				continue
+			}
+			if r.Topic != "" {
+				v.Topic(utils.JoinPath(rpath, "topic"), r.Topic, false)
+			}
+			for k, h := range r.Hosts {
+				if !utils.ValidHostPattern(h) {
+					v.Errorf(utils.IndexPath(utils.JoinPath(rpath, "hosts"), k), "invalid host [%s]", h)
+				}
+			}
+			for k, p := range r.Ports {
+				if p == 0 {
+					v.Errorf(utils.IndexPath(utils.JoinPath(rpath, "ports"), k), "invalid port 0")
+				}
+			}
+		}
+		if n.Port != 0 {
+			addr := networkAddr(n)
+			if j, ok := addrs[addr]; ok {
+				v.Errorf(utils.JoinPath(path, "port"), "duplicate listen address %s with networks[%d]", addr, j)
+			}
+			addrs[addr] = i
+		}
+	}
 
-	return conf
+	if this.Metrics != "" {
+		v.Addr("metrics", this.Metrics)
+	}
+	v.Log("log", this.Log)
+	v.Access("access_log", this.Access)
+	return v.Err()
 }
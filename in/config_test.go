@@ -0,0 +1,99 @@
+package in
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/qjw/proxy/utils"
+)
+
+func writeConf(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.json")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigNetworks(t *testing.T) {
+	// networks[0]和其他network一样校验必填字段
+	_, err := LoadConfig(writeConf(t, `{"networks": [{"bind": "127.0.0.1", "port": 40002}]}`), nil)
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	for _, field := range []string{"networks[0].server_host", "networks[0].server_port", "networks[0].topic"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("missing error of %s in:\n%s", field, err)
+		}
+	}
+
+	conf, err := LoadConfig(writeConf(t, `{"networks": [{"servers": ["10.0.0.1:40001", "10.0.0.2:40001"], "strategy": "latency", "bind": "127.0.0.1", "port": 40002, "topic": "db"}]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := conf.Networks[0]; n.ServerHost != "" || len(n.ServerAddrs()) != 2 {
+		t.Fatalf("unexpected servers %v", n.ServerAddrs())
+	}
+
+	conf, err = LoadConfig(writeConf(t, `{"metrics": "127.0.0.1:40012"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Networks) != 1 || conf.Networks[0].Port != 40002 {
+		t.Fatalf("default network not applied: %+v", conf.Networks)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	_, err := LoadConfig(writeConf(t, `{
+		"networks": [
+			{"servers": ["a:1", "a:1", "b"], "strategy": "nearest", "bind": "127.0.0.1", "port": 40002, "topic": "db"},
+			{"server_host": "bad host", "server_port": 40001, "bind": "127.0.0.1", "port": 40002, "topic": "db.*", "compress": "gzip"},
+			{"server_host": "p", "server_port": 40001, "bind": "127.0.0.1", "port": 40003, "topic": "db",
+				"routes": [{"hosts": ["bad host"], "ports": [0], "topic": "x"}]},
+			null
+		],
+		"metrics": "nowhere",
+		"log": {"level": "verbose"}
+	}`), nil)
+
+	expect := []string{
+		"log.level",
+		"metrics",
+		"networks[0].servers[1]",
+		"networks[0].servers[2]",
+		"networks[0].strategy",
+		"networks[1].compress",
+		"networks[1].port",
+		"networks[1].server_host",
+		"networks[1].topic",
+		"networks[2].routes",
+		"networks[2].routes[0].hosts[0]",
+		"networks[2].routes[0].ports[0]",
+		"networks[3]",
+	}
+	var errs utils.ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expect ConfigErrors, got %v", err)
+	}
+	paths := make([]string, 0, len(errs))
+	for _, v := range errs {
+		paths = append(paths, v.Path)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("got %v, expect %v", paths, expect)
+	}
+
+	// 类型错误同样带上路径
+	_, err = LoadConfig(writeConf(t, `{"networks": [{"port": "40002"}]}`), nil)
+	if err == nil || !strings.Contains(err.Error(), "networks[0].port") {
+		t.Fatalf("type error should carry the path, got %v", err)
+	}
+}
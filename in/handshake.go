@@ -0,0 +1,55 @@
+package in
+
+import (
+	"net"
+
+	"github.com/qjw/proxy/msg"
+)
+
+// 需要先和客户端协商目标地址的协议（SOCKS5、HTTP CONNECT）
+type handshaker interface {
+	// 读取客户端的请求，返回目标主机和端口；请求不支持时已经回复客户端
+	Handshake(conn net.Conn) (string, uint16, error)
+	// 回复建立隧道的结果
+	Reply(conn net.Conn, r result) error
+}
+
+// 建立隧道的结果，各协议按照自己的状态码回复客户端
+type result int
+
+const (
+	resultOK          result = iota
+	resultFailure            // 连接proxy失败等其他错误
+	resultUnreachable        // 没有可用的out，或者out连接后端失败
+	resultForbidden          // 认证失败、没有权限或者目标地址不允许
+	resultTimeout            // 等待空闲连接或者响应超时
+)
+
+func newHandshaker(mode string) handshaker {
+	switch mode {
+	case ModeSocks5:
+		return socks5{}
+	case ModeHTTP:
+		return httpConnect{}
+	}
+	return nil
+}
+
+// 按照proxy拒绝的原因（见server/stats.go）分类，旧版本的proxy没有返回原因
+func refusedResult(resp *msg.Response, err error) result {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return resultTimeout
+	}
+	if resp == nil {
+		return resultFailure
+	}
+	switch resp.Reason {
+	case "no_tunnel", "tunnel_shutdown", "backend_dial", "activate":
+		return resultUnreachable
+	case "timeout":
+		return resultTimeout
+	case "auth", "identity", "topic", "target":
+		return resultForbidden
+	}
+	return resultFailure
+}
@@ -0,0 +1,72 @@
+package in
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/qjw/proxy/utils"
+)
+
+// HTTP代理的CONNECT方法，建立隧道之后双方直接交换数据
+type httpConnect struct{}
+
+const maxHeaderBytes = 8 * 1024
+
+// 每次只读一个字节，避免读走请求头之后客户端发来的数据
+type byteReader struct {
+	r io.Reader
+}
+
+func (this byteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return this.r.Read(p[:1])
+}
+
+func (httpConnect) Handshake(conn net.Conn) (string, uint16, error) {
+	r := bufio.NewReader(io.LimitReader(byteReader{conn}, maxHeaderBytes))
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		if err != io.EOF {
+			httpReply(conn, http.StatusBadRequest)
+		}
+		return "", 0, err
+	}
+	if req.Method != http.MethodConnect {
+		httpReply(conn, http.StatusMethodNotAllowed)
+		return "", 0, fmt.Errorf("unsupported method %s", req.Method)
+	}
+	host, port, err := utils.SplitHostPort(req.Host)
+	if err != nil || !utils.ValidHost(host) {
+		httpReply(conn, http.StatusBadRequest)
+		return "", 0, fmt.Errorf("invalid target [%s]", req.Host)
+	}
+	return host, port, nil
+}
+
+var httpCodes = map[result]int{
+	resultOK:          http.StatusOK,
+	resultFailure:     http.StatusBadGateway,
+	resultUnreachable: http.StatusBadGateway,
+	resultForbidden:   http.StatusForbidden,
+	resultTimeout:     http.StatusGatewayTimeout,
+}
+
+func (httpConnect) Reply(conn net.Conn, r result) error {
+	return httpReply(conn, httpCodes[r])
+}
+
+func httpReply(conn net.Conn, code int) error {
+	var err error
+	if code == http.StatusOK {
+		_, err = fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	} else {
+		_, err = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+			code, http.StatusText(code))
+	}
+	return err
+}
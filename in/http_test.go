@@ -0,0 +1,92 @@
+package in
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHTTPConnectHandshake(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		host  string
+		port  uint16
+		reply string // 失败时回复的状态行
+	}{
+		{"connect", "CONNECT db.internal:3306 HTTP/1.1\r\nHost: db.internal:3306\r\n\r\n", "db.internal", 3306, ""},
+		{"ipv6", "CONNECT [::1]:22 HTTP/1.1\r\n\r\n", "::1", 22, ""},
+		{"get", "GET http://a.com/ HTTP/1.1\r\nHost: a.com\r\n\r\n", "", 0, "HTTP/1.1 405"},
+		{"no port", "CONNECT a.com HTTP/1.1\r\n\r\n", "", 0, "HTTP/1.1 400"},
+		{"zero port", "CONNECT a.com:0 HTTP/1.1\r\n\r\n", "", 0, "HTTP/1.1 400"},
+		{"invalid host", "CONNECT a_b!:80 HTTP/1.1\r\n\r\n", "", 0, "HTTP/1.1 400"},
+		{"garbage", "\x05\x01\x00", "", 0, "HTTP/1.1 400"},
+		{"truncated", "CONNECT a.com:80 HTTP/1.1\r\nHost: a", "", 0, "HTTP/1.1 400"},
+		{"too large", "CONNECT a.com:80 HTTP/1.1\r\nX: " + strings.Repeat("x", maxHeaderBytes) + "\r\n\r\n", "", 0, "HTTP/1.1 400"},
+		{"empty", "", "", 0, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := &scriptConn{in: strings.NewReader(c.input)}
+			host, port, err := httpConnect{}.Handshake(conn)
+			if c.host == "" {
+				if err == nil {
+					t.Fatalf("expect error, got %s:%d", host, port)
+				}
+				if !strings.HasPrefix(conn.out.String(), c.reply) || c.reply == "" && conn.out.Len() > 0 {
+					t.Fatalf("unexpected reply %q", conn.out.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host != c.host || port != c.port {
+				t.Fatalf("got %s:%d, expect %s:%d", host, port, c.host, c.port)
+			}
+			if conn.out.Len() != 0 {
+				t.Fatalf("reply before tunnel established: %q", conn.out.String())
+			}
+		})
+	}
+}
+
+// 请求头之后客户端紧接着发送的数据不能被读走
+func TestHTTPConnectKeepsPayload(t *testing.T) {
+	in := strings.NewReader("CONNECT a.com:443 HTTP/1.1\r\n\r\n\x16\x03\x01payload")
+	conn := &scriptConn{in: in}
+	if _, _, err := (httpConnect{}).Handshake(conn); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(in)
+	if !bytes.Equal(rest, []byte("\x16\x03\x01payload")) {
+		t.Fatalf("payload consumed, left %q", rest)
+	}
+}
+
+func TestHandshakeReply(t *testing.T) {
+	cases := []struct {
+		r     result
+		http  string
+		socks byte
+	}{
+		{resultOK, "HTTP/1.1 200", socksSucceeded},
+		{resultFailure, "HTTP/1.1 502", socksFailure},
+		{resultUnreachable, "HTTP/1.1 502", socksHostUnreachable},
+		{resultForbidden, "HTTP/1.1 403", socksNotAllowed},
+		{resultTimeout, "HTTP/1.1 504", socksTTLExpired},
+	}
+	for _, c := range cases {
+		conn := &scriptConn{}
+		httpConnect{}.Reply(conn, c.r)
+		if !strings.HasPrefix(conn.out.String(), c.http) {
+			t.Errorf("http reply of %v: %q", c.r, conn.out.String())
+		}
+		conn = &scriptConn{}
+		socks5{}.Reply(conn, c.r)
+		if b := conn.out.Bytes(); len(b) != 10 || b[1] != c.socks {
+			t.Errorf("socks reply of %v: %v", c.r, b)
+		}
+	}
+}
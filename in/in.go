@@ -1,28 +1,77 @@
-package main
+package in
 
 import (
-	"encoding/json"
-	"flag"
-	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/qjw/proxy/logs"
 	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/mux"
 	"github.com/qjw/proxy/utils"
 )
 
-var (
-	gConfig *Config = nil
-)
-
 type session struct {
 	c            *control
-	svr          *net.TCPConn
-	cli          *net.TCPConn
+	svr          net.Conn
+	cli          net.Conn
 	shutdown     *utils.Shutdown
 	loopShutdown *utils.Shutdown
 	network      *Network
+	hs           handshaker          // 协商目标地址的协议，tcp模式为空
+	topic        string              // 请求类别，SOCKS5/HTTP模式下按照目标地址选择
+	target       string              // SOCKS5/HTTP请求的目标地址host:port
+	sendTarget   bool                // 是否把目标地址带给out
+	caps         *utils.Capabilities // 和proxy协商的版本和特性
+	id           string              // 会话ID，经过proxy传给out，用于关联三端的日志
+	log          *logs.Logger        // 附带session、topic、remote字段，SOCKS5/HTTP模式下握手之后才有topic
+	created      time.Time           // 开始时间
+
+	lock     sync.Mutex
+	reason   string // 结束的原因，只记录第一个
+	err      string
+	result   result // 建立隧道的结果
+	bytesIn  int64  // 客户端发往proxy的字节数
+	bytesOut int64  // proxy发往客户端的字节数
+}
+
+// 记录结束的原因，已经记录过则忽略
+func (this *session) setReason(reason, err string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.reason == "" {
+		this.reason = reason
+		this.err = err
+	}
+}
+
+// 带上原因结束session
+func (this *session) Terminate(reason, err string) {
+	this.setReason(reason, err)
+	this.Shutdown()
+}
+
+// 写访问日志
+func (this *session) access() {
+	record := &logs.AccessRecord{
+		Start:   this.created,
+		End:     time.Now(),
+		Session: this.id,
+		Topic:   this.topic,
+		Client:  this.cli.RemoteAddr().String(),
+		Target:  this.target,
+	}
+	this.lock.Lock()
+	if this.svr != nil {
+		record.Upstream = this.svr.RemoteAddr().String()
+	}
+	record.BytesIn = this.bytesIn
+	record.BytesOut = this.bytesOut
+	record.Reason = this.reason
+	record.Error = this.err
+	this.lock.Unlock()
+	this.c.access.Write(record)
 }
 
 func (this *session) loop() {
@@ -34,41 +83,156 @@ func (this *session) loop() {
 	defer this.loopShutdown.Complete()
 	defer this.cli.Close()
 
-	// 收到请求之后，先连接服务器，确定之后再说
-	svrConn, err := net.Dial(
-		"tcp",
-		fmt.Sprintf("%s:%d", this.network.ServerHost, this.network.ServerPort),
-	)
-	if err != nil {
-		fmt.Println("Error connecting:", err)
-		return
+	log := this.log
+	if this.hs != nil {
+		if !this.handshake() {
+			return
+		}
+		log = log.With("topic", this.topic, "target", this.target)
 	}
-	defer svrConn.Close()
-	tcpConn, _ := svrConn.(*net.TCPConn)
-	this.svr = tcpConn
 
-	// 服务器发送请求
+	// 收到请求之后，先连接服务器，确定之后再说
 	uniqKey := utils.Magic()
+	log = log.With("magic", uniqKey)
 	initMsg := &msg.InRequest{
-		Magic:   uniqKey,
-		Version: utils.Version,
-		Type:    this.network.Topic,
+		Magic:      uniqKey,
+		Version:    utils.Version,
+		MinVersion: utils.MinVersion,
+		Features:   utils.Features,
+		Type:       this.topic,
+		Compress:   this.network.Compress,
+		Session:    this.id,
+	}
+	if this.sendTarget {
+		initMsg.Target = this.target
+	}
+	if this.network.Token != "" {
+		initMsg.Timestamp = time.Now().Unix()
+		initMsg.Sign = utils.Sign(this.network.Token, uniqKey, initMsg.Type, initMsg.Timestamp)
+	}
+
+	// 服务器发送请求，等待响应，proxy需要等待out接通后端，超时适当放宽。
+	// proxy拒绝时也算握手成功，不换下一个proxy
+	var resp *msg.Response
+	var respErr error
+	var dial, handshake time.Duration
+	start := time.Now()
+	svrConn, err := this.c.Dial(this.network, func(conn net.Conn) error {
+		hsStart := time.Now()
+		dial = hsStart.Sub(start)
+		msg.WriteMsg(conn, initMsg)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Duration(utils.HandshakeTimeout) * time.Millisecond))
+		resp, respErr = msg.ReadResponse(conn, uniqKey, "InRequest")
+		handshake = time.Since(hsStart)
+		if resp == nil {
+			return respErr
+		}
+		return nil
+	})
+	if he, ok := err.(*handshakeError); ok {
+		resp, respErr = nil, he.error
+	} else if err != nil {
+		sessionsFailed.Inc(this.topic, reasonDial)
+		this.setReason(reasonDial, err.Error())
+		log.Warn("connect to proxy failed", "error", err)
+		this.reply(resultFailure)
+		return
+	} else {
+		defer svrConn.Close()
+		this.lock.Lock()
+		this.svr = svrConn
+		this.lock.Unlock()
+	}
+	if respErr != nil {
+		sessionsFailed.Inc(this.topic, reasonRefused)
+		this.setReason(reasonRefused, respErr.Error())
+		log.Warn("session refused", "error", respErr, "dial", dial, "handshake", handshake)
+		this.reply(refusedResult(resp, respErr))
+		return
+	}
+	svrConn.SetReadDeadline(time.Time{})
+	this.caps = msg.ResponseCapabilities(resp)
+
+	// 旧版本的proxy会丢掉目标地址
+	if initMsg.Target != "" && !this.caps.Has(utils.FeatureTarget) {
+		sessionsFailed.Inc(this.topic, reasonRefused)
+		this.setReason(reasonRefused, "proxy does not support target")
+		log.Warn("session refused", "error", "proxy does not support target", "version", this.caps.Version)
+		this.reply(resultFailure)
+		return
 	}
-	msg.WriteMsg(tcpConn, initMsg)
 
-	// 等待响应
-	if err := msg.CheckResponse(tcpConn, uniqKey, "InRequest"); err != nil {
-		fmt.Println(err.Error())
+	// 和out之间压缩数据
+	dataConn, err := utils.Compress(svrConn, resp.Compress)
+	if err != nil {
+		sessionsFailed.Inc(this.topic, reasonCompress)
+		this.setReason(reasonCompress, err.Error())
+		log.Warn("compress failed", "error", err)
+		this.reply(resultFailure)
+		return
+	}
+	if err := this.reply(resultOK); err != nil {
+		this.setReason(reasonClientError, err.Error())
 		return
 	}
-	fmt.Printf("ok,start session data exchange %p\n", this)
+	log.Info("start data exchange", "compress", resp.Compress, "dial", dial, "handshake", handshake)
+	sessionsStarted.Inc(this.topic)
 
 	// 开始数据交换
-	utils.Join(this.cli, this.svr, this)
+	var fromBytes, toBytes int64
+	first, err := utils.JoinCounted(this.cli, dataConn, this, &fromBytes, &toBytes)
+	switch {
+	case first == this.cli && err == nil:
+		this.setReason(reasonClientEOF, "")
+	case first == this.cli:
+		this.setReason(reasonClientError, err.Error())
+	case err == nil:
+		this.setReason(reasonUpstreamEOF, "")
+	default:
+		this.setReason(reasonUpstreamError, err.Error())
+	}
+	this.lock.Lock()
+	this.bytesIn, this.bytesOut = toBytes, fromBytes
+	this.lock.Unlock()
+	sessionBytes.Add(float64(toBytes), this.topic, "in")
+	sessionBytes.Add(float64(fromBytes), this.topic, "out")
+	log.Info("data exchange finished", "bytes_in", toBytes, "bytes_out", fromBytes)
+}
 
+// 读取SOCKS5/HTTP CONNECT请求，按照目标地址选择topic
+func (this *session) handshake() bool {
+	this.cli.SetDeadline(time.Now().Add(time.Duration(utils.HandshakeTimeout) * time.Millisecond))
+	host, port, err := this.hs.Handshake(this.cli)
+	if err != nil {
+		sessionsFailed.Inc(this.topic, reasonHandshake)
+		this.setReason(reasonHandshake, err.Error())
+		this.log.Warn("client handshake failed", "mode", this.network.Mode, "error", err)
+		return false
+	}
+	this.cli.SetDeadline(time.Time{})
+
+	topic, sendTarget := this.network.Route(host, port)
+	this.lock.Lock()
+	this.topic = topic
+	this.target = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	this.sendTarget = sendTarget
+	this.lock.Unlock()
+	this.log.Debug("client request", "target", this.target, "topic", topic, "send_target", sendTarget)
+	return true
+}
+
+// 回复客户端建立隧道的结果，tcp模式只记录
+func (this *session) reply(r result) error {
+	this.lock.Lock()
+	this.result = r
+	this.lock.Unlock()
+	if this.hs == nil {
+		return nil
+	}
+	return this.hs.Reply(this.cli, r)
 }
 
-func (this session) Shutdown() {
+func (this *session) Shutdown() {
 	this.shutdown.Begin()
 }
 
@@ -82,18 +246,17 @@ func (this *session) Run() {
 	go this.loop()
 	// 连接服务器
 	this.shutdown.WaitBegin()
-	fmt.Printf("start to shutdown session %p\n", this)
+	this.log.Debug("start to shutdown session")
 
 	// 关闭socket
-	this.cli.CloseRead()
-	this.cli.CloseWrite()
+	utils.CloseReadWrite(this.cli)
 	if this.svr != nil {
-		this.svr.CloseRead()
-		this.svr.CloseWrite()
+		utils.CloseReadWrite(this.svr)
 	}
 
 	// 等待loop结束
 	this.loopShutdown.WaitComplete()
+	this.access()
 }
 
 ///////////////////////////////////////////////////////////////////////////
@@ -101,27 +264,120 @@ func (this *session) Run() {
 type control struct {
 	sessions map[*session]int
 	sync.Mutex
+	access *logs.AccessLog // 访问日志，没有配置时为nil
+
+	muxLock sync.Mutex
+	muxes   map[*Network]*mux.Session // 每个network一个多路复用连接
+
+	servers *serverPool // proxy的健康状态和连接耗时
 }
 
-func NewControl() *control {
+func NewControl(access *logs.AccessLog) *control {
 	return &control{
+		access:   access,
 		sessions: make(map[*session]int),
+		muxes:    make(map[*Network]*mux.Session),
+		servers:  newServerPool(),
 	}
 }
 
-func (this *control) NewSession(cli *net.TCPConn, network *Network) {
+// 连接服务器并调用handshake发送请求，配置了多个服务器时按照策略依次尝试，握手超时或者连接出错也换下一个；
+// 开启多路复用时在共享的连接上新建stream，多路复用连接建立时已经握手过，stream握手失败不再切换
+func (this *control) Dial(network *Network, handshake func(conn net.Conn) error) (net.Conn, error) {
+	if !network.Mux {
+		var conn net.Conn
+		err := this.tryServers(network, func(host string, port uint16) error {
+			var err error
+			if conn, err = utils.Dial(host, port, network.TLS); err != nil {
+				return err
+			}
+			if err := handshake(conn); err != nil {
+				conn.Close()
+				return &handshakeError{err}
+			}
+			return nil
+		})
+		return conn, err
+	}
+
+	stream, err := this.openStream(network)
+	if err != nil {
+		return nil, err
+	}
+	if err := handshake(stream); err != nil {
+		stream.Close()
+		return nil, &handshakeError{err}
+	}
+	return stream, nil
+}
+
+// 在network共享的多路复用连接上新建stream，没有连接时先建立
+func (this *control) openStream(network *Network) (net.Conn, error) {
+	this.muxLock.Lock()
+	defer this.muxLock.Unlock()
+
+	session, ok := this.muxes[network]
+	if !ok || session.IsClosed() {
+		if err := this.tryServers(network, func(host string, port uint16) error {
+			var err error
+			session, err = mux.Dial(host, port, network.TLS)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		logs.Info("new mux session", "server", session.RemoteAddr().String())
+		muxSessions.Inc(session.RemoteAddr().String())
+		this.muxes[network] = session
+	}
+	return session.Open()
+}
+
+// 关闭已经从配置中删除并且没有stream的多路复用连接
+func (this *control) PruneMux(networks []*Network) {
+	inUse := make(map[*Network]bool)
+	for _, v := range networks {
+		inUse[v] = true
+	}
+
+	this.muxLock.Lock()
+	defer this.muxLock.Unlock()
+	for k, v := range this.muxes {
+		if inUse[k] {
+			continue
+		}
+		if v.IsClosed() || v.NumStreams() == 0 {
+			v.Close()
+			delete(this.muxes, k)
+		}
+	}
+}
+
+func (this *control) NewSession(cli net.Conn, network *Network) {
+	go this.newSession(cli, network).Run()
+}
+
+func (this *control) newSession(cli net.Conn, network *Network) *session {
 	s := &session{
 		c:            this,
 		cli:          cli,
 		shutdown:     utils.NewShutdown(true),
 		loopShutdown: utils.NewShutdown(false),
 		network:      network,
+		hs:           newHandshaker(network.Mode),
+		topic:        network.Topic,
+		id:           utils.RandId(8),
+		created:      time.Now(),
 	}
-	go s.Run()
+	if s.hs != nil {
+		s.log = logs.With("session", s.id, "remote", cli.RemoteAddr().String())
+	} else {
+		s.log = logs.With("session", s.id, "topic", network.Topic, "remote", cli.RemoteAddr().String())
+	}
+	return s
 }
 
 func (this *control) Add(s *session) {
-	fmt.Printf("add session %p\n", s)
+	s.log.Debug("add session")
 	this.Lock()
 	defer this.Unlock()
 
@@ -133,7 +389,7 @@ func (this *control) Add(s *session) {
 }
 
 func (this *control) Del(s *session) {
-	fmt.Printf("del session %p\n", s)
+	s.log.Debug("del session")
 	this.Lock()
 	defer this.Unlock()
 
@@ -144,18 +400,18 @@ func (this *control) Del(s *session) {
 	}
 }
 
-func (this control) Shutdown() {
-	fmt.Println("start to shutdown control\n")
+func (this *control) Shutdown() {
+	logs.Info("start to shutdown control")
 	this.Lock()
 
 	// 尝试关闭
 	for k, _ := range this.sessions {
-		k.Shutdown()
+		k.Terminate(reasonShutdown, "")
 	}
 	this.Unlock()
 }
 
-func (this control) WaitComplele() {
+func (this *control) WaitComplele() {
 	for {
 		time.Sleep(time.Millisecond * 100)
 		this.Lock()
@@ -168,93 +424,3 @@ func (this control) WaitComplele() {
 		this.Unlock()
 	}
 }
-
-///////////////////////////////////////////////////////////////////////////
-func checkConfig() {
-	if jsonBytes, err := json.MarshalIndent(gConfig, "", "    "); err != nil {
-		panic(err)
-	} else {
-		fmt.Println(string(jsonBytes))
-	}
-	if len(gConfig.Networks) < 1 || len(gConfig.Networks) > 16 {
-		panic("invalid network config,network count in(1,16")
-	}
-
-	// 不同host/port 的subject可以相同
-	//	topics := make(map[string]int)
-	//	for _, v := range gConfig.Networks {
-	//		topics[v.Topic] = 0
-	//	}
-	//	if len(topics) != len(gConfig.Networks) {
-	//		panic("topic can not duplicate")
-	//	}
-}
-
-func main() {
-	confPath := flag.String("config", "", "配置文件")
-	flag.Parse()
-	if confPath == nil || *confPath == "" {
-		gConfig = defaultConfig()
-	} else {
-		gConfig = parseConfig(*confPath)
-	}
-	checkConfig()
-
-	fmt.Println("Starting the server ...")
-	utils.RandomSeed()
-
-	listenrs := make(map[net.Listener]*Network)
-	c := NewControl()
-
-	// 信号 和谐的退出
-	exitMng := utils.NewExitManager()
-	go exitMng.Run(func() {
-		for k, _ := range listenrs {
-			k.Close()
-		}
-		c.Shutdown()
-	})
-
-	for _, v := range gConfig.Networks {
-		network := v
-
-		// tcp服务器
-		listener, err := net.Listen(
-			"tcp",
-			fmt.Sprintf("%s:%d", network.Bind, network.Port),
-		)
-		if err != nil {
-			fmt.Println("Error listening", err.Error())
-			return
-		}
-		listenrs[listener] = network
-	}
-
-	var wait sync.WaitGroup
-	wait.Add(len(gConfig.Networks))
-	for k, v := range listenrs {
-		listener := k
-		network := v
-		go func() {
-			defer wait.Done()
-			for {
-				conn, err := listener.Accept()
-				if conn == nil {
-					fmt.Println("listener accept ended")
-					break
-				}
-				if err != nil {
-					fmt.Println("Error accepting", err.Error())
-					break
-				}
-
-				tcpConn, _ := conn.(*net.TCPConn)
-				c.NewSession(tcpConn, network)
-			}
-		}()
-	}
-
-	// 等待结束
-	wait.Wait()
-	c.WaitComplele()
-}
@@ -0,0 +1,150 @@
+package in
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// 一个本地监听端口，network可以在重新加载配置时替换
+type listener struct {
+	net.Listener
+	lock    sync.Mutex
+	network *Network
+}
+
+func (this *listener) Network() *Network {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.network
+}
+
+func (this *listener) setNetwork(network *Network) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.network = network
+}
+
+// 所有监听端口，按照bind:port区分
+type listeners struct {
+	sync.Mutex
+	c      *control
+	items  map[string]*listener
+	closed bool
+	wait   sync.WaitGroup
+}
+
+func NewListeners(c *control) *listeners {
+	return &listeners{
+		c:     c,
+		items: make(map[string]*listener),
+	}
+}
+
+func networkAddr(network *Network) string {
+	return net.JoinHostPort(network.Bind, fmt.Sprintf("%d", network.Port))
+}
+
+// 按照配置增删监听端口
+//
+// 新增的端口先全部监听成功才会生效，失败时保持原样；地址不变而其他配置变化的，
+// 只替换network，之后的新会话使用新配置，已有的会话不受影响
+func (this *listeners) Apply(networks []*Network) error {
+	this.Lock()
+	defer this.Unlock()
+	if this.closed {
+		return fmt.Errorf("listeners closed")
+	}
+
+	wanted := make(map[string]*Network)
+	for _, v := range networks {
+		addr := networkAddr(v)
+		if _, ok := wanted[addr]; ok {
+			return fmt.Errorf("duplicate listen address %s", addr)
+		}
+		wanted[addr] = v
+	}
+
+	added := make(map[string]*listener)
+	for addr, network := range wanted {
+		if _, ok := this.items[addr]; ok {
+			continue
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, v := range added {
+				v.Close()
+			}
+			return err
+		}
+		added[addr] = &listener{Listener: l, network: network}
+	}
+
+	// 先启动新的监听，避免WaitGroup归零导致程序退出
+	for addr, l := range added {
+		this.items[addr] = l
+		this.wait.Add(1)
+		go this.serve(l)
+		logs.Info("listening", "addr", addr, "topic", l.network.Topic)
+	}
+
+	for addr, l := range this.items {
+		network, ok := wanted[addr]
+		if !ok {
+			logs.Info("stop listening", "addr", addr)
+			l.Close()
+			delete(this.items, addr)
+			continue
+		}
+		if _, ok := added[addr]; !ok && !reflect.DeepEqual(network, l.Network()) {
+			logs.Info("listener config updated", "addr", addr, "topic", network.Topic)
+			l.setNetwork(network)
+		}
+	}
+	return nil
+}
+
+func (this *listeners) serve(l *listener) {
+	defer this.wait.Done()
+	for {
+		conn, err := l.Accept()
+		if conn == nil {
+			logs.Info("listener accept ended", "addr", l.Addr().String())
+			break
+		}
+		if err != nil {
+			logs.Error("accept failed", "error", err)
+			break
+		}
+
+		this.c.NewSession(conn, l.Network())
+	}
+}
+
+// 正在使用的network
+func (this *listeners) Networks() []*Network {
+	this.Lock()
+	defer this.Unlock()
+	list := make([]*Network, 0, len(this.items))
+	for _, v := range this.items {
+		list = append(list, v.Network())
+	}
+	return list
+}
+
+// 关闭所有监听，之后的Apply都会失败
+func (this *listeners) Close() {
+	this.Lock()
+	defer this.Unlock()
+	this.closed = true
+	for _, v := range this.items {
+		v.Close()
+	}
+}
+
+func (this *listeners) Wait() {
+	this.wait.Wait()
+}
@@ -0,0 +1,33 @@
+package in
+
+import (
+	"reflect"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// 替换配置（SIGHUP）
+//
+// 增删监听端口，修改的network对之后的新会话生效，已有的会话不受影响；
+// 监控指标和访问日志需要重启
+func (this *In) Reload(conf *Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	this.reloadLock.Lock()
+	defer this.reloadLock.Unlock()
+
+	old := this.Config()
+	if conf.Metrics != old.Metrics || !reflect.DeepEqual(conf.Access, old.Access) {
+		logs.Warn("changes of metrics/access_log require restart, ignored")
+		conf.Metrics, conf.Access = old.Metrics, old.Access
+	}
+
+	if err := this.l.Apply(conf.Networks); err != nil {
+		return err
+	}
+	this.c.PruneMux(this.l.Networks())
+	this.conf.Store(conf)
+	logs.Info("config reloaded", "networks", len(conf.Networks))
+	return nil
+}
@@ -0,0 +1,95 @@
+package in
+
+import (
+	"net"
+	"testing"
+)
+
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func testNetwork(port uint16, topic string) *Network {
+	return &Network{
+		ServerHost: "127.0.0.1",
+		ServerPort: 1,
+		Bind:       "127.0.0.1",
+		Port:       port,
+		Topic:      topic,
+	}
+}
+
+func TestReloadNetworks(t *testing.T) {
+	portA, portB := freePort(t), freePort(t)
+	conf := DefaultConfig()
+	conf.Networks = []*Network{testNetwork(portA, "a")}
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		s.Shutdown()
+		s.Wait()
+	}()
+	addrA := s.Addr(conf.Networks[0])
+
+	// 修改a的topic并新增b：a的端口保持监听，只替换network
+	conf2 := DefaultConfig()
+	conf2.Networks = []*Network{testNetwork(portA, "a2"), testNetwork(portB, "b")}
+	conf2.Metrics = "127.0.0.1:40012"
+	if err := s.Reload(conf2); err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr(conf2.Networks[0]) != addrA {
+		t.Fatal("listener of unchanged address should be kept")
+	}
+	if s.Addr(conf2.Networks[1]) == nil {
+		t.Fatal("new network not listening")
+	}
+	s.l.Lock()
+	topic := s.l.items[addrA.String()].Network().Topic
+	s.l.Unlock()
+	if topic != "a2" {
+		t.Fatalf("network not replaced, topic %s", topic)
+	}
+	if s.Config().Metrics != "" {
+		t.Fatal("metrics change should require restart")
+	}
+
+	// 新的端口监听失败时保持原样
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	conf3 := DefaultConfig()
+	conf3.Networks = []*Network{testNetwork(portA, "a2"), testNetwork(uint16(busy.Addr().(*net.TCPAddr).Port), "c")}
+	if err := s.Reload(conf3); err == nil {
+		t.Fatal("reload with busy port should fail")
+	}
+	if s.Addr(conf2.Networks[1]) == nil || s.Config() != conf2 {
+		t.Fatal("failed reload should keep the old listeners and config")
+	}
+
+	// 删除a
+	conf4 := DefaultConfig()
+	conf4.Networks = []*Network{testNetwork(portB, "b")}
+	if err := s.Reload(conf4); err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr(conf2.Networks[0]) != nil {
+		t.Fatal("removed network still listening")
+	}
+	if _, err := net.Dial("tcp", addrA.String()); err == nil {
+		t.Fatal("removed port still accepts connections")
+	}
+}
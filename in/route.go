@@ -0,0 +1,28 @@
+package in
+
+import (
+	"github.com/qjw/proxy/utils"
+)
+
+// SOCKS5/HTTP模式下按照目标地址选择topic，按照顺序匹配第一条
+type Route struct {
+	Hosts  []string `json:"hosts"`                    // 目标主机：域名、*.example.com、IP或者CIDR，为空匹配所有
+	Ports  []uint16 `json:"ports"`                    // 目标端口，为空匹配所有
+	Topic  string   `json:"topic" binding:"required"` // 请求类别
+	Target bool     `json:"target"`                   // 把目标地址带给out，由out直接连接；否则连接out配置的后端
+}
+
+// 域名不解析，CIDR只匹配IP形式的目标
+func (this *Route) Match(host string, port uint16) bool {
+	return utils.MatchAddr(this.Hosts, this.Ports, host, port)
+}
+
+// 选择目标地址对应的topic，没有匹配的规则时使用network的topic并带上目标地址
+func (this *Network) Route(host string, port uint16) (string, bool) {
+	for _, v := range this.Routes {
+		if v.Match(host, port) {
+			return v.Topic, v.Target
+		}
+	}
+	return this.Topic, true
+}
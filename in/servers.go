@@ -0,0 +1,136 @@
+package in
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/utils"
+)
+
+// 选择proxy的策略
+const (
+	StrategyFailover = "failover" // 按照配置的顺序，前面的不可用时使用下一个（默认）
+	StrategyRandom   = "random"   // 随机
+	StrategyLatency  = "latency"  // 连接耗时最短的优先
+)
+
+// 一个proxy的状态
+type serverState struct {
+	downUntil time.Time     // 之前连接失败，在此之前标记为不可用
+	latency   time.Duration // 连接耗时的滑动平均，0表示还没有成功连接过
+}
+
+// 所有proxy的状态，按照地址区分，network之间以及重新加载配置前后共享
+type serverPool struct {
+	sync.Mutex
+	states map[string]*serverState
+}
+
+func newServerPool() *serverPool {
+	return &serverPool{
+		states: make(map[string]*serverState),
+	}
+}
+
+func (this *serverPool) state(addr string) *serverState {
+	s, ok := this.states[addr]
+	if !ok {
+		s = &serverState{}
+		this.states[addr] = s
+	}
+	return s
+}
+
+// 按照策略排列尝试的顺序，标记为不可用的排在最后（保持原来的顺序），只有其他都失败时才尝试
+func (this *serverPool) Order(addrs []string, strategy string) []string {
+	this.Lock()
+	defer this.Unlock()
+
+	healthy := make([]string, 0, len(addrs))
+	unhealthy := make([]string, 0)
+	now := time.Now()
+	for _, v := range addrs {
+		if now.Before(this.state(v).downUntil) {
+			unhealthy = append(unhealthy, v)
+		} else {
+			healthy = append(healthy, v)
+		}
+	}
+
+	switch strategy {
+	case StrategyRandom:
+		rand.Shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	case StrategyLatency:
+		// 没有连接过的排在前面，得到它的耗时
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return this.state(healthy[i]).latency < this.state(healthy[j]).latency
+		})
+	}
+	return append(healthy, unhealthy...)
+}
+
+// 连接成功，清除不可用的标记并更新耗时
+func (this *serverPool) Success(addr string, latency time.Duration) {
+	this.Lock()
+	defer this.Unlock()
+	s := this.state(addr)
+	if !s.downUntil.IsZero() {
+		logs.Info("proxy server recovered", "server", addr)
+		s.downUntil = time.Time{}
+	}
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = (s.latency*7 + latency) / 8
+	}
+}
+
+// 连接失败，标记为不可用
+func (this *serverPool) Failure(addr string) {
+	this.Lock()
+	defer this.Unlock()
+	this.state(addr).downUntil = time.Now().Add(time.Duration(utils.UnhealthyInterval) * time.Millisecond)
+}
+
+// 和proxy握手失败（超时、连接出错、响应无法解析），区别于连接不上proxy
+type handshakeError struct {
+	error
+}
+
+// 按照策略依次尝试network的proxy，直到dial成功，返回最后一个错误
+func (this *control) tryServers(network *Network, dial func(host string, port uint16) error) error {
+	var lastErr error
+	for _, addr := range this.servers.Order(network.ServerAddrs(), network.Strategy) {
+		host, port, err := utils.SplitHostPort(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		start := time.Now()
+		if err := dial(host, port); err != nil {
+			this.servers.Failure(addr)
+			serverFailures.Inc(addr)
+			logs.Warn("connect to proxy failed, mark as unhealthy", "server", addr, "error", err)
+			lastErr = err
+			continue
+		}
+		this.servers.Success(addr, time.Since(start))
+		return nil
+	}
+	return lastErr
+}
+
+// 配置的所有proxy地址，没有配置servers时使用server_host和server_port
+func (this *Network) ServerAddrs() []string {
+	if len(this.Servers) > 0 {
+		return this.Servers
+	}
+	return []string{net.JoinHostPort(this.ServerHost, strconv.Itoa(int(this.ServerPort)))}
+}
@@ -0,0 +1,151 @@
+package in
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 接受连接之后不做任何回复的proxy
+func hungProxy(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		l.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, v := range conns {
+			v.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			conns = append(conns, conn)
+			lock.Unlock()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// 接受连接之后立即关闭的proxy
+func closingProxy(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// 接受连接之后回复一个字节的proxy
+func replyingProxy(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte{1})
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestServerPoolOrder(t *testing.T) {
+	addrs := []string{"a:1", "b:1", "c:1"}
+	order := func(p *serverPool, strategy string, expect ...string) {
+		t.Helper()
+		if got := p.Order(addrs, strategy); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("%s order %v, expect %v", strategy, got, expect)
+		}
+	}
+
+	p := newServerPool()
+	order(p, StrategyFailover, "a:1", "b:1", "c:1")
+	// 不可用的排在最后，保持配置的顺序
+	p.Failure("a:1")
+	order(p, StrategyFailover, "b:1", "c:1", "a:1")
+	p.Failure("b:1")
+	order(p, StrategyFailover, "c:1", "a:1", "b:1")
+	// 连接成功后恢复
+	p.Success("a:1", time.Millisecond)
+	order(p, StrategyFailover, "a:1", "c:1", "b:1")
+
+	// 没有连接过的先试，其他按照耗时排列
+	p = newServerPool()
+	p.Success("a:1", 30*time.Millisecond)
+	p.Success("b:1", 10*time.Millisecond)
+	order(p, StrategyLatency, "c:1", "b:1", "a:1")
+	p.Failure("c:1")
+	order(p, StrategyLatency, "b:1", "a:1", "c:1")
+
+	// 随机时不可用的也排在最后
+	for i := 0; i < 10; i++ {
+		if got := p.Order(addrs, StrategyRandom); got[2] != "c:1" {
+			t.Fatalf("random order %v, expect c:1 last", got)
+		}
+	}
+}
+
+func TestDialFailover(t *testing.T) {
+	hung, good := hungProxy(t), replyingProxy(t)
+
+	c := NewControl(nil)
+	network := testNetwork(1, "db")
+	network.Servers = []string{hung, good}
+	conn, err := c.Dial(network, func(conn net.Conn) error {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != good {
+		t.Fatalf("connected to %s, expect %s", conn.RemoteAddr(), good)
+	}
+	// 握手超时的proxy标记为不可用
+	if got := c.servers.Order(network.Servers, StrategyFailover); got[0] != good {
+		t.Fatalf("order %v, expect %s first", got, good)
+	}
+}
+
+// 第一个proxy握手时断开连接，切换到第二个；第二个拒绝时不再切换，按照拒绝的原因返回
+func TestStdioFailover(t *testing.T) {
+	network := testNetwork(1, "db")
+	refusing := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(refusingProxy(t, "no_tunnel"))))
+	network.Servers = []string{closingProxy(t), refusing}
+	if code := runStdio(t, network); code != ExitNoTunnel {
+		t.Fatalf("exit code %d, expect %d", code, ExitNoTunnel)
+	}
+}
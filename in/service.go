@@ -0,0 +1,80 @@
+package in
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// in服务，可以嵌入到其他程序中：New之后Start，Shutdown之后Wait等待所有会话结束
+type In struct {
+	conf       atomic.Pointer[Config] // 重新加载配置时整体替换
+	reloadLock sync.Mutex
+	c          *control
+	l          *listeners
+}
+
+// 校验配置并打开访问日志
+func New(conf *Config) (*In, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	access, err := logs.OpenAccessLog(conf.Access, "in")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &In{
+		c: NewControl(access),
+	}
+	s.l = NewListeners(s.c)
+	s.conf.Store(conf)
+	return s, nil
+}
+
+// 当前的配置，不要修改
+func (this *In) Config() *Config {
+	return this.conf.Load()
+}
+
+// 监听所有network的端口，任何一个失败则都不监听
+func (this *In) Start() error {
+	if err := this.l.Apply(this.Config().Networks); err != nil {
+		return err
+	}
+	addControl(this.c)
+	return nil
+}
+
+// network对应的监听地址，没有监听时返回nil
+func (this *In) Addr(network *Network) net.Addr {
+	this.l.Lock()
+	defer this.l.Unlock()
+	if l, ok := this.l.items[networkAddr(network)]; ok {
+		return l.Addr()
+	}
+	return nil
+}
+
+// 停止监听并断开所有的会话
+func (this *In) Shutdown() {
+	this.l.Close()
+	this.c.Shutdown()
+}
+
+// 等待所有的会话结束
+func (this *In) Wait() {
+	this.l.Wait()
+	this.c.WaitComplele()
+
+	// 关闭多路复用连接
+	this.c.muxLock.Lock()
+	for _, v := range this.c.muxes {
+		v.Close()
+	}
+	this.c.muxLock.Unlock()
+	delControl(this.c)
+	this.c.access.Close()
+}
@@ -0,0 +1,130 @@
+package in
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5（RFC 1928），只支持无认证的CONNECT
+const (
+	socksVersion      byte = 0x05
+	socksNoAuth       byte = 0x00
+	socksNoAcceptable byte = 0xff
+	socksConnect      byte = 0x01
+
+	socksAtypIPv4   byte = 0x01
+	socksAtypDomain byte = 0x03
+	socksAtypIPv6   byte = 0x04
+)
+
+// 回复码
+const (
+	socksSucceeded           byte = 0x00
+	socksFailure             byte = 0x01
+	socksNotAllowed          byte = 0x02
+	socksHostUnreachable     byte = 0x04
+	socksRefused             byte = 0x05
+	socksTTLExpired          byte = 0x06
+	socksCmdUnsupported      byte = 0x07
+	socksAddrTypeUnsupported byte = 0x08
+)
+
+type socks5 struct{}
+
+// 协商认证方式并读取CONNECT请求
+func (socks5) Handshake(conn net.Conn) (string, uint16, error) {
+	// VER NMETHODS METHODS
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", 0, err
+	}
+	if buf[0] != socksVersion {
+		return "", 0, fmt.Errorf("invalid socks version %d", buf[0])
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", 0, err
+	}
+	method := socksNoAcceptable
+	for _, v := range methods {
+		if v == socksNoAuth {
+			method = socksNoAuth
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", 0, err
+	}
+	if method == socksNoAcceptable {
+		return "", 0, fmt.Errorf("no acceptable auth method")
+	}
+
+	// VER CMD RSV ATYP DST.ADDR DST.PORT
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return "", 0, err
+	}
+	if buf[0] != socksVersion {
+		return "", 0, fmt.Errorf("invalid socks version %d", buf[0])
+	}
+	cmd, atyp := buf[1], buf[3]
+
+	var host string
+	switch atyp {
+	case socksAtypIPv4, socksAtypIPv6:
+		size := net.IPv4len
+		if atyp == socksAtypIPv6 {
+			size = net.IPv6len
+		}
+		if _, err := io.ReadFull(conn, buf[:size]); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(buf[:size]).String()
+	case socksAtypDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return "", 0, err
+		}
+		size := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:size]); err != nil {
+			return "", 0, err
+		}
+		host = string(buf[:size])
+	default:
+		socksReply(conn, socksAddrTypeUnsupported)
+		return "", 0, fmt.Errorf("unsupported address type %d", atyp)
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", 0, err
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+
+	if cmd != socksConnect {
+		socksReply(conn, socksCmdUnsupported)
+		return "", 0, fmt.Errorf("unsupported command %d", cmd)
+	}
+	if host == "" || port == 0 {
+		socksReply(conn, socksFailure)
+		return "", 0, fmt.Errorf("invalid target %s", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	}
+	return host, port, nil
+}
+
+var socksCodes = map[result]byte{
+	resultOK:          socksSucceeded,
+	resultFailure:     socksFailure,
+	resultUnreachable: socksHostUnreachable,
+	resultForbidden:   socksNotAllowed,
+	resultTimeout:     socksTTLExpired,
+}
+
+func (socks5) Reply(conn net.Conn, r result) error {
+	return socksReply(conn, socksCodes[r])
+}
+
+// 回复CONNECT请求，绑定地址总是0.0.0.0:0
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
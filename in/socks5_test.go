@@ -0,0 +1,102 @@
+package in
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// 读取固定的输入，记录写出的数据，只用于不需要其他net.Conn方法的握手
+type scriptConn struct {
+	net.Conn
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (this *scriptConn) Read(b []byte) (int, error) {
+	return this.in.Read(b)
+}
+
+func (this *scriptConn) Write(b []byte) (int, error) {
+	return this.out.Write(b)
+}
+
+// 客户端发送input，返回握手的结果以及in回复的字节
+func socksHandshake(t *testing.T, input []byte) (string, uint16, error, []byte) {
+	t.Helper()
+	conn := &scriptConn{in: bytes.NewReader(input)}
+	host, port, err := socks5{}.Handshake(conn)
+	return host, port, err, conn.out.Bytes()
+}
+
+func TestSocks5Handshake(t *testing.T) {
+	greeting := []byte{socksVersion, 1, socksNoAuth}
+	connect := func(atyp byte, addr ...byte) []byte {
+		b := append(append([]byte{}, greeting...), socksVersion, socksConnect, 0, atyp)
+		return append(b, addr...)
+	}
+	manyMethods := []byte{socksVersion, 255}
+	for i := 0; i < 255; i++ {
+		manyMethods = append(manyMethods, byte(i+1))
+	}
+	manyMethods[len(manyMethods)-1] = socksNoAuth
+
+	cases := []struct {
+		name  string
+		input []byte
+		host  string
+		port  uint16
+		err   bool
+	}{
+		{"ipv4", connect(socksAtypIPv4, 10, 1, 2, 3, 0x0c, 0xea), "10.1.2.3", 3306, false},
+		{"ipv6", connect(socksAtypIPv6, append(net.ParseIP("::1"), 0, 22)...), "::1", 22, false},
+		{"domain", connect(socksAtypDomain, append([]byte{7}, append([]byte("a.b.com"), 0, 80)...)...), "a.b.com", 80, false},
+		{"255 methods", append(manyMethods, socksVersion, socksConnect, 0, socksAtypIPv4, 1, 2, 3, 4, 0, 80), "1.2.3.4", 80, false},
+		{"empty domain", connect(socksAtypDomain, 0, 0, 80), "", 0, true},
+		{"zero port", connect(socksAtypIPv4, 1, 2, 3, 4, 0, 0), "", 0, true},
+		{"bad version", []byte{0x04, 1, socksNoAuth}, "", 0, true},
+		{"no acceptable method", []byte{socksVersion, 1, 0x02}, "", 0, true},
+		{"bind command", append(append([]byte{}, greeting...), socksVersion, 0x02, 0, socksAtypIPv4, 1, 2, 3, 4, 0, 80), "", 0, true},
+		{"bad address type", connect(0x09), "", 0, true},
+		{"truncated greeting", []byte{socksVersion, 3, socksNoAuth}, "", 0, true},
+		{"truncated request", connect(socksAtypIPv4, 1, 2), "", 0, true},
+		{"truncated domain", connect(socksAtypDomain, 10, 'a', 'b'), "", 0, true},
+		{"empty", nil, "", 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			host, port, err, _ := socksHandshake(t, c.input)
+			if c.err {
+				if err == nil {
+					t.Fatalf("expect error, got %s:%d", host, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if host != c.host || port != c.port {
+				t.Fatalf("got %s:%d, expect %s:%d", host, port, c.host, c.port)
+			}
+		})
+	}
+}
+
+func TestSocks5Reply(t *testing.T) {
+	_, _, err, replied := socksHandshake(t, []byte{socksVersion, 1, 0x02})
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	if !bytes.Equal(replied, []byte{socksVersion, socksNoAcceptable}) {
+		t.Fatalf("unexpected reply %v", replied)
+	}
+
+	_, _, err, replied = socksHandshake(t, []byte{socksVersion, 1, socksNoAuth, socksVersion, 0x03, 0, socksAtypIPv4, 1, 2, 3, 4, 0, 80})
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	if len(replied) != 12 || replied[3] != socksCmdUnsupported {
+		t.Fatalf("unexpected reply %v", replied)
+	}
+}
@@ -0,0 +1,67 @@
+package in
+
+import (
+	"sync"
+
+	"github.com/qjw/proxy/metrics"
+)
+
+// 监控指标，配置metrics之后以Prometheus文本格式暴露
+var (
+	sessionsStarted = metrics.NewCounter("in_sessions_started_total",
+		"Sessions accepted by proxy and forwarding.", "topic")
+	sessionsFailed = metrics.NewCounter("in_sessions_failed_total",
+		"Sessions failed before data exchange.", "topic", "reason")
+	sessionBytes = metrics.NewCounter("in_bytes_total",
+		"Bytes exchanged by finished sessions, direction in (client->proxy) or out (proxy->client).", "topic", "direction")
+	muxSessions = metrics.NewCounter("in_mux_sessions_total",
+		"Multiplexed connections established to proxy, including reconnects.", "server")
+	serverFailures = metrics.NewCounter("in_server_dial_failures_total",
+		"Failed connections to proxy, the server is marked unhealthy and the next one is tried.", "server")
+)
+
+// 失败的原因，作为reason标签，也用作访问日志中连接结束的原因
+const (
+	reasonDial      = "dial"
+	reasonRefused   = "refused"
+	reasonCompress  = "compress"
+	reasonHandshake = "handshake"
+
+	// 数据交换开始之后
+	reasonClientEOF     = "client_eof"
+	reasonClientError   = "client_error"
+	reasonUpstreamEOF   = "upstream_eof"
+	reasonUpstreamError = "upstream_error"
+	reasonShutdown      = "shutdown"
+)
+
+// 正在运行的control，gauge抓取时汇总
+var (
+	controlsLock sync.Mutex
+	controls     = make(map[*control]bool)
+)
+
+func addControl(c *control) {
+	controlsLock.Lock()
+	defer controlsLock.Unlock()
+	controls[c] = true
+}
+
+func delControl(c *control) {
+	controlsLock.Lock()
+	defer controlsLock.Unlock()
+	delete(controls, c)
+}
+
+var _ = metrics.NewGaugeFunc("in_active_sessions",
+	"Sessions currently forwarding or handshaking.", func() []metrics.Sample {
+		controlsLock.Lock()
+		defer controlsLock.Unlock()
+		sum := 0
+		for c := range controls {
+			c.Lock()
+			sum += len(c.sessions)
+			c.Unlock()
+		}
+		return []metrics.Sample{{Value: float64(sum)}}
+	})
@@ -0,0 +1,109 @@
+package in
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/utils"
+)
+
+// 标准输入输出模式的退出码
+const (
+	ExitOK          = 0
+	ExitError       = 1 // 配置错误等其他错误
+	ExitUsage       = 2 // 命令行参数错误
+	ExitUnreachable = 3 // 连接proxy失败
+	ExitNoTunnel    = 4 // topic没有可用的out，或者out连接后端失败
+	ExitForbidden   = 5 // 认证失败、没有权限或者目标地址不允许
+	ExitTimeout     = 6 // 等待响应超时
+)
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+// 标准输入输出包装成net.Conn
+type stdioConn struct {
+	in  *os.File
+	out *os.File
+}
+
+func (this *stdioConn) Read(b []byte) (int, error)  { return this.in.Read(b) }
+func (this *stdioConn) Write(b []byte) (int, error) { return this.out.Write(b) }
+
+func (this *stdioConn) Close() error {
+	this.in.Close()
+	return this.out.Close()
+}
+
+// 关闭stdout通知对方（例如ssh）数据已经结束；阻塞在stdin上的读取要等对方关闭管道才返回
+func (this *stdioConn) CloseRead() error  { return this.in.Close() }
+func (this *stdioConn) CloseWrite() error { return this.out.Close() }
+
+func (this *stdioConn) LocalAddr() net.Addr                { return stdioAddr{} }
+func (this *stdioConn) RemoteAddr() net.Addr               { return stdioAddr{} }
+func (this *stdioConn) SetDeadline(t time.Time) error      { return nil }
+func (this *stdioConn) SetReadDeadline(t time.Time) error  { return nil }
+func (this *stdioConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// 选择topic对应的network（证书、密钥、压缩等），没有则复制第一个network并替换topic；topic为空使用第一个network
+func (this *Config) StdioNetwork(topic string) *Network {
+	if topic == "" {
+		return this.Networks[0]
+	}
+	for _, v := range this.Networks {
+		if v.Topic == topic {
+			return v
+		}
+	}
+	n := *this.Networks[0]
+	n.Topic = topic
+	return &n
+}
+
+// 把标准输入输出接到network的隧道上，用作ssh的ProxyCommand；target不为空时带给out（exit模式）。
+// 返回退出码，隧道建立之后总是返回ExitOK
+func Stdio(network *Network, target string, access *logs.AccessLog) int {
+	if network.Mode != "" && network.Mode != ModeTCP {
+		// 目标地址由参数指定，不需要和客户端协商
+		n := *network
+		n.Mode = ""
+		network = &n
+	}
+	c := NewControl(access)
+	s := c.newSession(&stdioConn{in: os.Stdin, out: os.Stdout}, network)
+	s.result = resultFailure // 隧道建立之后变为resultOK
+	if target != "" {
+		s.target, s.sendTarget = target, true
+		s.log = s.log.With("target", target)
+	}
+
+	// 信号 和谐的退出
+	go utils.NewExitManager().Run(c.Shutdown)
+	s.Run()
+
+	c.muxLock.Lock()
+	for _, v := range c.muxes {
+		v.Close()
+	}
+	c.muxLock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case s.reason == reasonDial:
+		return ExitUnreachable
+	case s.result == resultOK:
+		return ExitOK
+	case s.result == resultUnreachable:
+		return ExitNoTunnel
+	case s.result == resultForbidden:
+		return ExitForbidden
+	case s.result == resultTimeout:
+		return ExitTimeout
+	}
+	return ExitError
+}
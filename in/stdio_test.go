@@ -0,0 +1,93 @@
+package in
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/utils"
+)
+
+// 只回复拒绝原因的proxy
+func refusingProxy(t *testing.T, reason string) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			m, _, err := msg.ReadMsg(conn)
+			if req, ok := m.(*msg.InRequest); err == nil && ok {
+				msg.WriteMsg(conn, &msg.Response{
+					Magic:   req.Magic,
+					Request: "InRequest",
+					Version: utils.Version,
+					Message: "refused",
+					Reason:  reason,
+				})
+			}
+			conn.Close()
+		}
+	}()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+// 用管道代替标准输入输出执行Stdio
+func runStdio(t *testing.T, network *Network) int {
+	t.Helper()
+	stdin, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdoutR, stdout, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdinW.Close()
+	defer stdoutR.Close()
+	go io.Copy(io.Discard, stdoutR)
+
+	oldIn, oldOut := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	defer func() {
+		os.Stdin, os.Stdout = oldIn, oldOut
+	}()
+	return Stdio(network, "", nil)
+}
+
+func TestStdioExitCode(t *testing.T) {
+	closed := freePort(t)
+	cases := []struct {
+		name   string
+		port   uint16
+		reason string
+		code   int
+	}{
+		{"unreachable", closed, "", ExitUnreachable},
+		{"no tunnel", 0, "no_tunnel", ExitNoTunnel},
+		{"backend dial", 0, "backend_dial", ExitNoTunnel},
+		{"forbidden", 0, "auth", ExitForbidden},
+		{"other", 0, "pool_full", ExitError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			port := c.port
+			if port == 0 {
+				port = refusingProxy(t, c.reason)
+			}
+			network := testNetwork(1, "db")
+			network.ServerPort = port
+			if code := runStdio(t, network); code != c.code {
+				t.Fatalf("exit code %d, expect %d", code, c.code)
+			}
+		})
+	}
+}
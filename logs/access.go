@@ -0,0 +1,131 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 访问日志，每个结束的连接一条记录
+//
+// text格式是逗号分隔的一行，字段顺序同AccessRecord：
+//
+//	start,end,duration_ms,session,topic,client,identity,upstream,tunnel,bytes_in,bytes_out,reason,error,target
+type AccessConfig struct {
+	Output     string `json:"output"`      // stdout/stderr/syslog/syslog://host:port/文件路径，为空不开启
+	Format     string `json:"format"`      // json（默认）/text
+	MaxSize    int    `json:"max_size"`    // 输出到文件时单个文件的最大长度(MB)，超过则轮转
+	MaxBackups int    `json:"max_backups"` // 输出到文件时保留的轮转文件数
+}
+
+type AccessRecord struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration int64     `json:"duration_ms"`
+	Session  string    `json:"session"`
+	Topic    string    `json:"topic"`
+	Client   string    `json:"client"`             // 发起连接的地址
+	Identity string    `json:"identity,omitempty"` // 发起方的证书身份
+	Upstream string    `json:"upstream,omitempty"` // 转发的目标：proxy上是out，in上是proxy，out上是后端
+	Tunnel   string    `json:"tunnel,omitempty"`   // proxy上服务该连接的tunnel id
+	BytesIn  int64     `json:"bytes_in"`           // client发往upstream的字节数
+	BytesOut int64     `json:"bytes_out"`          // upstream发往client的字节数
+	Reason   string    `json:"reason"`             // 结束的原因
+	Error    string    `json:"error,omitempty"`
+	Target   string    `json:"target,omitempty"` // SOCKS5等请求的目标地址
+}
+
+type AccessLog struct {
+	sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// 没有配置output时返回nil，nil的AccessLog不记录
+func OpenAccessLog(conf *AccessConfig, tag string) (*AccessLog, error) {
+	if conf == nil || conf.Output == "" {
+		return nil, nil
+	}
+	switch conf.Format {
+	case "", FormatJSON, FormatText:
+	default:
+		return nil, fmt.Errorf("invalid access log format [%s]", conf.Format)
+	}
+
+	w, err := openOutput(&Config{
+		Output:     conf.Output,
+		MaxSize:    conf.MaxSize,
+		MaxBackups: conf.MaxBackups,
+	}, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLog{
+		w:      w,
+		format: conf.Format,
+	}, nil
+}
+
+func (this *AccessLog) Write(r *AccessRecord) {
+	if this == nil {
+		return
+	}
+	r.Duration = r.End.Sub(r.Start).Milliseconds()
+
+	var line []byte
+	if this.format == FormatText {
+		line = r.text()
+	} else {
+		var err error
+		if line, err = json.Marshal(r); err != nil {
+			Error("marshal access record failed", "error", err)
+			return
+		}
+		line = append(line, '\n')
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	if _, err := this.w.Write(line); err != nil {
+		Error("write access log failed", "error", err)
+	}
+}
+
+// 关闭输出的文件或者syslog连接，nil时忽略
+func (this *AccessLog) Close() {
+	if this == nil || this.w == os.Stdout || this.w == os.Stderr {
+		return
+	}
+	if c, ok := this.w.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func (this *AccessRecord) text() []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		this.Start.Format(time.RFC3339Nano),
+		this.End.Format(time.RFC3339Nano),
+		strconv.FormatInt(this.Duration, 10),
+		this.Session,
+		this.Topic,
+		this.Client,
+		this.Identity,
+		this.Upstream,
+		this.Tunnel,
+		strconv.FormatInt(this.BytesIn, 10),
+		strconv.FormatInt(this.BytesOut, 10),
+		this.Reason,
+		this.Error,
+		this.Target,
+	})
+	w.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,124 @@
+package logs
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// 分级的结构化日志，三个程序共用
+//
+//	logs.Info("register topic ok", "topic", tp, "version", v)
+//	log := logs.With("session", id, "remote", addr)
+//	log.Debug("start data exchange")
+const (
+	FormatText string = "text" // logfmt（默认）
+	FormatJSON string = "json"
+
+	OutputStderr string = "stderr" // 默认
+	OutputStdout string = "stdout"
+	OutputSyslog string = "syslog" // 本机syslog，或者syslog://host:port（udp）
+)
+
+type Config struct {
+	Level      string `json:"level"`       // debug/info/warn/error，默认info
+	Format     string `json:"format"`      // text/json，默认text
+	Output     string `json:"output"`      // stderr/stdout/syslog/syslog://host:port，其他视为文件路径
+	MaxSize    int    `json:"max_size"`    // 输出到文件时单个文件的最大长度(MB)，超过则轮转
+	MaxBackups int    `json:"max_backups"` // 输出到文件时保留的轮转文件数
+}
+
+type Logger = slog.Logger
+
+func init() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Level:      "info",
+		Format:     FormatText,
+		Output:     OutputStderr,
+		MaxSize:    100,
+		MaxBackups: 5,
+	}
+}
+
+func openOutput(conf *Config, tag string) (io.Writer, error) {
+	switch {
+	case conf.Output == "" || conf.Output == OutputStderr:
+		return os.Stderr, nil
+	case conf.Output == OutputStdout:
+		return os.Stdout, nil
+	case conf.Output == OutputSyslog:
+		return openSyslog("", tag)
+	case strings.HasPrefix(conf.Output, OutputSyslog+"://"):
+		return openSyslog(strings.TrimPrefix(conf.Output, OutputSyslog+"://"), tag)
+	default:
+		return openRotate(conf.Output, int64(conf.MaxSize)<<20, conf.MaxBackups)
+	}
+}
+
+// 按照配置替换默认的Logger，tag是程序名，用于syslog
+func Setup(conf *Config, tag string) error {
+	if conf == nil {
+		conf = DefaultConfig()
+	}
+
+	var level slog.Level
+	if conf.Level != "" {
+		if err := level.UnmarshalText([]byte(conf.Level)); err != nil {
+			return fmt.Errorf("invalid log level [%s]", conf.Level)
+		}
+	}
+
+	w, err := openOutput(conf, tag)
+	if err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch conf.Format {
+	case "", FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format [%s]", conf.Format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// 重新加载配置时调用，配置没有变化则保留原来的Logger
+func Update(old, conf *Config, tag string) error {
+	if reflect.DeepEqual(old, conf) {
+		return nil
+	}
+	return Setup(conf, tag)
+}
+
+// 附带关联字段（topic、session、remote、magic……）的Logger
+func With(args ...interface{}) *Logger {
+	return slog.Default().With(args...)
+}
+
+func Debug(msg string, args ...interface{}) {
+	slog.Default().Debug(msg, args...)
+}
+
+func Info(msg string, args ...interface{}) {
+	slog.Default().Info(msg, args...)
+}
+
+func Warn(msg string, args ...interface{}) {
+	slog.Default().Warn(msg, args...)
+}
+
+func Error(msg string, args ...interface{}) {
+	slog.Default().Error(msg, args...)
+}
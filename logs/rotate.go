@@ -0,0 +1,77 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// 按照大小轮转的日志文件，path.1是最近轮转的文件
+type rotateWriter struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotate(path string, maxSize int64, maxBackups int) (*rotateWriter, error) {
+	w := &rotateWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (this *rotateWriter) open() error {
+	file, err := os.OpenFile(this.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	this.file = file
+	this.size = info.Size()
+	return nil
+}
+
+func (this *rotateWriter) rotate() error {
+	this.file.Close()
+	if this.maxBackups <= 0 {
+		os.Remove(this.path)
+	} else {
+		for i := this.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", this.path, i), fmt.Sprintf("%s.%d", this.path, i+1))
+		}
+		os.Rename(this.path, this.path+".1")
+	}
+	return this.open()
+}
+
+func (this *rotateWriter) Write(b []byte) (int, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.maxSize > 0 && this.size > 0 && this.size+int64(len(b)) > this.maxSize {
+		if err := this.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := this.file.Write(b)
+	this.size += int64(n)
+	return n, err
+}
+
+func (this *rotateWriter) Close() error {
+	this.Lock()
+	defer this.Unlock()
+	return this.file.Close()
+}
@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package logs
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// addr为空时写本机的syslog，否则通过udp发送到addr
+func openSyslog(addr, tag string) (io.Writer, error) {
+	if addr == "" {
+		return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	}
+	return syslog.Dial("udp", addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
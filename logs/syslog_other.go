@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package logs
+
+import (
+	"fmt"
+	"io"
+)
+
+func openSyslog(addr, tag string) (io.Writer, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
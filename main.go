@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/qjw/proxy/in"
+	"github.com/qjw/proxy/out"
+	"github.com/qjw/proxy/server"
+	"github.com/qjw/proxy/utils"
+)
+
+const usage = `usage: proxy <command> [flags]
+
+commands:
+  server    运行proxy服务器
+  in        运行in，把本地端口的请求转发到proxy
+  out       运行out，向proxy注册并连接后端
+  version   显示版本号
+
+proxy <command> -h 查看子命令的参数
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "server":
+		os.Exit(server.Main("proxy server", args))
+	case "in":
+		os.Exit(in.Main("proxy in", args))
+	case "out":
+		os.Exit(out.Main("proxy out", args))
+	case "version":
+		fmt.Println(utils.Version)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qjw/proxy/in"
+	"github.com/qjw/proxy/out"
+	"github.com/qjw/proxy/server"
+)
+
+// 获取一个空闲的本地端口
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+// 本地的echo后端
+func echoBackend(t *testing.T) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+// 通过in的端口发送数据，读取echo回来的数据
+func echo(addr string, data []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return nil, err
+	}
+	return got, nil
+}
+
+// 在同一个进程中启动proxy、out和in，经过in的端口访问out后面的echo后端
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		inMux    bool
+		outMux   bool
+		compress string
+	}{
+		{"plain", false, false, ""},
+		{"mux", true, true, ""},
+		{"compress", false, true, "deflate"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			backend := echoBackend(t)
+
+			svrConf := server.DefaultConfig()
+			svrConf.Bind = "127.0.0.1"
+			svrConf.Port = freePort(t)
+			svr, err := server.New(svrConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svr.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				svr.Shutdown()
+				svr.Wait()
+			}()
+
+			outConf := out.DefaultConfig()
+			outConf.RetryInterval = 100
+			outConf.Networks[0].ServerPort = svrConf.Port
+			outConf.Networks[0].BackendPort = backend
+			outConf.Networks[0].Mux = c.outMux
+			o, err := out.New(outConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.Start()
+			defer func() {
+				o.Shutdown()
+				o.Wait()
+			}()
+
+			inConf := in.DefaultConfig()
+			network := inConf.Networks[0]
+			network.ServerPort = svrConf.Port
+			network.Port = freePort(t)
+			network.Mux = c.inMux
+			network.Compress = c.compress
+			i, err := in.New(inConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := i.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				i.Shutdown()
+				i.Wait()
+			}()
+			addr := i.Addr(network).String()
+
+			// out注册是异步的，注册之前in的会话会被拒绝
+			data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				got, err := echo(addr, data)
+				if err == nil {
+					if !bytes.Equal(got, data) {
+						t.Fatal("echo data mismatch")
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("round trip failed: %s", err)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			// 多个并发会话
+			errs := make(chan error, 8)
+			for n := 0; n < cap(errs); n++ {
+				go func() {
+					got, err := echo(addr, data[:4096])
+					if err == nil && !bytes.Equal(got, data[:4096]) {
+						err = io.ErrUnexpectedEOF
+					}
+					errs <- err
+				}()
+			}
+			for n := 0; n < cap(errs); n++ {
+				if err := <-errs; err != nil {
+					t.Fatalf("concurrent session: %s", err)
+				}
+			}
+		})
+	}
+}
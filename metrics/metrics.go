@@ -0,0 +1,313 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// 简单的Prometheus文本格式指标，只依赖标准库
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// 一组标签值对应的样本
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type metric interface {
+	name() string
+	write(buf *bytes.Buffer)
+}
+
+type Registry struct {
+	sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// 三个程序各自使用默认的Registry
+var Default = NewRegistry()
+
+func (this *Registry) register(m metric) {
+	this.Lock()
+	defer this.Unlock()
+	for _, v := range this.metrics {
+		if v.name() == m.name() {
+			panic(fmt.Sprintf("duplicate metric %s", m.name()))
+		}
+	}
+	this.metrics = append(this.metrics, m)
+}
+
+func (this *Registry) WriteTo(buf *bytes.Buffer) {
+	this.Lock()
+	list := make([]metric, len(this.metrics))
+	copy(list, this.metrics)
+	this.Unlock()
+
+	for _, v := range list {
+		v.write(buf)
+	}
+}
+
+func (this *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	this.WriteTo(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// 监听addr暴露/metrics
+func Serve(addr string) {
+	router := http.NewServeMux()
+	router.Handle("/metrics", Default)
+	logs.Info("metrics listening", "addr", addr)
+	if err := http.ListenAndServe(addr, router); err != nil {
+		logs.Error("metrics listen failed", "error", err)
+	}
+}
+
+func escape(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	return strings.Replace(v, `"`, `\"`, -1)
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, n, escape(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], escape(extra[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, tp string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, tp)
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+// counter和gauge，按照标签值分组
+type Vec struct {
+	sync.Mutex
+	n      string
+	help   string
+	tp     string
+	labels []string
+	values map[string]*Sample
+}
+
+func newVec(tp, name, help string, labels []string) *Vec {
+	v := &Vec{
+		n:      name,
+		help:   help,
+		tp:     tp,
+		labels: labels,
+		values: make(map[string]*Sample),
+	}
+	Default.register(v)
+	return v
+}
+
+func NewCounter(name, help string, labels ...string) *Vec {
+	return newVec(typeCounter, name, help, labels)
+}
+
+func NewGauge(name, help string, labels ...string) *Vec {
+	return newVec(typeGauge, name, help, labels)
+}
+
+func (this *Vec) name() string {
+	return this.n
+}
+
+func (this *Vec) sample(values []string) *Sample {
+	if len(values) != len(this.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels", this.n, len(this.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := this.values[key]
+	if !ok {
+		s = &Sample{Labels: append([]string(nil), values...)}
+		this.values[key] = s
+	}
+	return s
+}
+
+func (this *Vec) Add(delta float64, values ...string) {
+	this.Lock()
+	defer this.Unlock()
+	this.sample(values).Value += delta
+}
+
+func (this *Vec) Inc(values ...string) {
+	this.Add(1, values...)
+}
+
+// 只用于gauge
+func (this *Vec) Set(v float64, values ...string) {
+	this.Lock()
+	defer this.Unlock()
+	this.sample(values).Value = v
+}
+
+func (this *Vec) write(buf *bytes.Buffer) {
+	this.Lock()
+	defer this.Unlock()
+
+	writeHeader(buf, this.n, this.help, this.tp)
+	keys := make([]string, 0, len(this.values))
+	for k := range this.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := this.values[k]
+		fmt.Fprintf(buf, "%s%s %s\n", this.n, formatLabels(this.labels, s.Labels), formatValue(s.Value))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+// 抓取时才计算的gauge，例如空闲连接池的深度
+type GaugeFunc struct {
+	n      string
+	help   string
+	labels []string
+	fn     func() []Sample
+}
+
+func NewGaugeFunc(name, help string, fn func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{
+		n:      name,
+		help:   help,
+		labels: labels,
+		fn:     fn,
+	}
+	Default.register(g)
+	return g
+}
+
+func (this *GaugeFunc) name() string {
+	return this.n
+}
+
+func (this *GaugeFunc) write(buf *bytes.Buffer) {
+	writeHeader(buf, this.n, this.help, typeGauge)
+	for _, s := range this.fn() {
+		fmt.Fprintf(buf, "%s%s %s\n", this.n, formatLabels(this.labels, s.Labels), formatValue(s.Value))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+type histogramSample struct {
+	labels []string
+	counts []uint64 // 每个桶的计数（不累加）
+	count  uint64
+	sum    float64
+}
+
+type Histogram struct {
+	sync.Mutex
+	n       string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramSample
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		n:       name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramSample),
+	}
+	Default.register(h)
+	return h
+}
+
+func (this *Histogram) name() string {
+	return this.n
+}
+
+func (this *Histogram) Observe(v float64, values ...string) {
+	this.Lock()
+	defer this.Unlock()
+
+	if len(values) != len(this.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels", this.n, len(this.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := this.values[key]
+	if !ok {
+		s = &histogramSample{
+			labels: append([]string(nil), values...),
+			counts: make([]uint64, len(this.buckets)),
+		}
+		this.values[key] = s
+	}
+
+	for i, b := range this.buckets {
+		if v <= b {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (this *Histogram) write(buf *bytes.Buffer) {
+	this.Lock()
+	defer this.Unlock()
+
+	writeHeader(buf, this.n, this.help, typeHistogram)
+	keys := make([]string, 0, len(this.values))
+	for k := range this.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := this.values[k]
+		var cumulative uint64
+		for i, b := range this.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", this.n,
+				formatLabels(this.labels, s.labels, "le", formatValue(b)), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", this.n,
+			formatLabels(this.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", this.n, formatLabels(this.labels, s.labels), formatValue(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", this.n, formatLabels(this.labels, s.labels), s.count)
+	}
+}
@@ -0,0 +1,189 @@
+package msg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// 紧凑的二进制编码：首字节binaryMagic，第二个字节是类型编号，之后按照结构体字段的声明顺序依次编码
+//
+//	string   uvarint长度 + 内容
+//	int      varint
+//	bool     1个字节
+//	[]string uvarint个数 + 逐个string
+//
+// 字段只能在结构体末尾追加，解码时缺少的字段保持零值，多余的字节忽略
+const binaryMagic byte = 0x00
+
+// 类型编号，只能在末尾追加
+var binaryTypes = []string{
+	"OutRequest",
+	"OutDataRequest",
+	"DataActiveRequest",
+	"InRequest",
+	"NewDataRequest",
+	"Response",
+	"Ping",
+	"Pong",
+	"MuxRequest",
+}
+
+var binaryIds map[string]byte
+
+func init() {
+	binaryIds = make(map[string]byte)
+	for i, v := range binaryTypes {
+		binaryIds[v] = byte(i + 1)
+	}
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return CodecBinary
+}
+
+func (binaryCodec) Pack(payload Message) ([]byte, error) {
+	v := reflect.ValueOf(payload).Elem()
+	id, ok := binaryIds[v.Type().Name()]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported message type %s", v.Type().Name())
+	}
+
+	var err error
+	buf := []byte{binaryMagic, id}
+	for i := 0; i < v.NumField(); i++ {
+		if buf, err = appendValue(buf, v.Field(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.String:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		buf = append(buf, v.String()...)
+	case reflect.Bool:
+		if v.Bool() {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf = binary.AppendVarint(buf, v.Int())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported field type %s", v.Type())
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			buf, _ = appendValue(buf, v.Index(i))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return buf, nil
+}
+
+func (binaryCodec) Unpack(buffer []byte, msgIn Message) (msg Message, tp string, err error) {
+	if len(buffer) < 2 || buffer[0] != binaryMagic {
+		err = errors.New("invalid binary message")
+		return
+	}
+
+	id := int(buffer[1])
+	if id < 1 || id > len(binaryTypes) {
+		err = fmt.Errorf("Unsupported message type id %d", id)
+		return
+	}
+	tp = binaryTypes[id-1]
+
+	if msgIn == nil {
+		msg = reflect.New(TypeMap[tp]).Interface().(Message)
+	} else {
+		msg = msgIn
+	}
+
+	v := reflect.ValueOf(msg).Elem()
+	if v.Type().Name() != tp {
+		err = fmt.Errorf("unexpected message type %s", tp)
+		return
+	}
+
+	r := &binaryReader{buf: buffer[2:]}
+	for i := 0; i < v.NumField() && len(r.buf) > 0; i++ {
+		if err = r.readValue(v.Field(i)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+type binaryReader struct {
+	buf []byte
+}
+
+func (this *binaryReader) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(this.buf)
+	if n <= 0 {
+		return 0, errors.New("invalid binary message")
+	}
+	this.buf = this.buf[n:]
+	return x, nil
+}
+
+func (this *binaryReader) str() (string, error) {
+	l, err := this.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if l > uint64(len(this.buf)) {
+		return "", errors.New("invalid binary message")
+	}
+	s := string(this.buf[:l])
+	this.buf = this.buf[l:]
+	return s, nil
+}
+
+func (this *binaryReader) readValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := this.str()
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		v.SetBool(this.buf[0] != 0)
+		this.buf = this.buf[1:]
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, n := binary.Varint(this.buf)
+		if n <= 0 {
+			return errors.New("invalid binary message")
+		}
+		this.buf = this.buf[n:]
+		v.SetInt(x)
+	case reflect.Slice:
+		count, err := this.uvarint()
+		if err != nil {
+			return err
+		}
+		if count > uint64(len(this.buf)) {
+			return errors.New("invalid binary message")
+		}
+		list := reflect.MakeSlice(v.Type(), int(count), int(count))
+		for i := 0; i < int(count); i++ {
+			if err := this.readValue(list.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
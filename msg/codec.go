@@ -0,0 +1,70 @@
+package msg
+
+// 报文的编解码
+type Codec interface {
+	Name() string
+	Pack(payload Message) ([]byte, error)
+	Unpack(buffer []byte, msgIn Message) (msg Message, tp string, err error)
+}
+
+const (
+	CodecJSON   string = "json"
+	CodecBinary string = "binary"
+)
+
+var (
+	JSON   Codec = jsonCodec{}
+	Binary Codec = binaryCodec{}
+
+	// 按照优先级排列，握手时默认按这个顺序提供
+	Codecs = []Codec{Binary, JSON}
+)
+
+func CodecNames() []string {
+	names := make([]string, 0, len(Codecs))
+	for _, v := range Codecs {
+		names = append(names, v.Name())
+	}
+	return names
+}
+
+func GetCodec(name string) Codec {
+	for _, v := range Codecs {
+		if v.Name() == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// 从对方提供的列表中按照对方的优先级选择一个支持的，都不支持时使用JSON
+func NegotiateCodec(offered []string) Codec {
+	for _, name := range offered {
+		if c := GetCodec(name); c != nil {
+			return c
+		}
+	}
+	return JSON
+}
+
+// 根据报文的首字节判断编码，握手报文总是JSON，之后的报文使用协商的编码
+func detectCodec(buffer []byte) Codec {
+	if len(buffer) > 0 && buffer[0] == binaryMagic {
+		return Binary
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (jsonCodec) Pack(payload Message) ([]byte, error) {
+	return Pack(payload)
+}
+
+func (jsonCodec) Unpack(buffer []byte, msgIn Message) (Message, string, error) {
+	return unpack(buffer, msgIn)
+}
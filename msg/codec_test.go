@@ -0,0 +1,204 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 按照字段类型填充非零值
+func fill(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		name := v.Type().Field(i).Name
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(name + "-值")
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f.SetInt(-int64(i+1) * 1000003)
+		case reflect.Slice:
+			f.Set(reflect.ValueOf([]string{name, "", "b"}))
+		}
+	}
+}
+
+func allMessages() []Message {
+	list := make([]Message, 0, len(binaryTypes))
+	for _, tp := range binaryTypes {
+		m := reflect.New(TypeMap[tp])
+		fill(m.Elem())
+		list = append(list, m.Interface())
+	}
+	return list
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range Codecs {
+		for _, m := range allMessages() {
+			name := reflect.TypeOf(m).Elem().Name()
+			buf, err := codec.Pack(m)
+			if err != nil {
+				t.Fatalf("%s pack %s: %s", codec.Name(), name, err)
+			}
+			if detectCodec(buf) != codec {
+				t.Errorf("%s: detect codec of %s failed", codec.Name(), name)
+			}
+			got, tp, err := codec.Unpack(buf, nil)
+			if err != nil {
+				t.Fatalf("%s unpack %s: %s", codec.Name(), name, err)
+			}
+			if tp != name || !reflect.DeepEqual(got, m) {
+				t.Errorf("%s: %s round trip mismatch\n got %+v\nwant %+v", codec.Name(), name, got, m)
+			}
+		}
+	}
+}
+
+// 截断的报文不能panic：二进制编码缺少的字段保持零值，截断在字段中间时报错
+func TestBinaryTruncated(t *testing.T) {
+	for _, m := range allMessages() {
+		buf, err := Binary.Pack(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(buf); i++ {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("unpack %T truncated at %d panics: %v", m, i, r)
+					}
+				}()
+				Binary.Unpack(buf[:i], nil)
+			}()
+		}
+	}
+}
+
+func TestBinaryInvalid(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		{binaryMagic},
+		{binaryMagic, 0},
+		{binaryMagic, byte(len(binaryTypes) + 1)},
+		// 字符串长度超出报文
+		{binaryMagic, binaryIds["NewDataRequest"], 0x7f, 'a'},
+		// 数组个数超出报文
+		append([]byte{binaryMagic, binaryIds["OutRequest"], 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0xff, 0x01),
+		// 非法的varint
+		append([]byte{binaryMagic, binaryIds["OutRequest"], 0, 0, 0}, bytes.Repeat([]byte{0xff}, 11)...),
+	}
+	for _, c := range cases {
+		if _, _, err := Binary.Unpack(c, nil); err == nil {
+			t.Errorf("unpack %v should fail", c)
+		}
+	}
+
+	// 类型和期望的不一致
+	buf, _ := Binary.Pack(&Ping{})
+	if _, _, err := Binary.Unpack(buf, &Pong{}); err == nil {
+		t.Error("unpack Ping into Pong should fail")
+	}
+}
+
+func TestJSONInvalid(t *testing.T) {
+	cases := []string{
+		``,
+		`{`,
+		`{"Type": "Unknown", "Payload": {}}`,
+		`{"Type": "NewDataRequest", "Payload": {"magic": 1}}`,
+	}
+	for _, c := range cases {
+		if _, _, err := JSON.Unpack([]byte(c), nil); err == nil {
+			t.Errorf("unpack %s should fail", c)
+		}
+	}
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	cases := []struct {
+		offered []string
+		expect  Codec
+	}{
+		{nil, JSON},
+		{[]string{"unknown"}, JSON},
+		{[]string{CodecJSON, CodecBinary}, JSON},
+		{[]string{"unknown", CodecBinary}, Binary},
+	}
+	for _, c := range cases {
+		if got := NegotiateCodec(c.offered); got != c.expect {
+			t.Errorf("NegotiateCodec(%v) = %s, expect %s", c.offered, got.Name(), c.expect.Name())
+		}
+	}
+}
+
+func TestReadMsgFrame(t *testing.T) {
+	frame := func(size int64, payload []byte) []byte {
+		buf := make([]byte, 8, 8+len(payload))
+		binary.LittleEndian.PutUint64(buf, uint64(size))
+		return append(buf, payload...)
+	}
+	ping, _ := Binary.Pack(&NewDataRequest{Magic: "m"})
+
+	cases := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{"ok", frame(int64(len(ping)), ping), ""},
+		{"zero size", frame(0, nil), "invalid frame size"},
+		{"negative size", frame(-1, nil), "invalid frame size"},
+		{"too large", frame(maxFrameSize+1, nil), "invalid frame size"},
+		{"truncated payload", frame(int64(len(ping))+10, ping), "Expected to read"},
+		{"truncated size", []byte{1, 2, 3}, "EOF"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			go func() {
+				client.Write(c.input)
+				client.Close()
+			}()
+			m, _, err := ReadMsg(server)
+			server.Close()
+			if c.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p, ok := m.(*NewDataRequest); !ok || p.Magic != "m" {
+					t.Fatalf("unexpected message %+v", m)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got %v, expect %s", err, c.err)
+			}
+		})
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	write := func(resp *Response) net.Conn {
+		client, server := net.Pipe()
+		go func() {
+			WriteMsg(client, resp)
+			client.Close()
+		}()
+		return server
+	}
+
+	if _, err := ReadResponse(write(&Response{Magic: "m", Request: "InRequest"}), "m", "InRequest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadResponse(write(&Response{Magic: "x", Request: "InRequest"}), "m", "InRequest"); err == nil {
+		t.Fatal("magic mismatch should fail")
+	}
+	resp, err := ReadResponse(write(&Response{Magic: "m", Request: "InRequest", Message: "refused", Reason: "auth"}), "m", "InRequest")
+	if err == nil || resp == nil || resp.Reason != "auth" {
+		t.Fatalf("refused response: %+v, %v", resp, err)
+	}
+}
@@ -4,9 +4,23 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync/atomic"
+
+	"github.com/qjw/proxy/utils"
 )
 
+// 单个报文的最大长度，防止恶意的长度字段耗尽内存
+var maxFrameSize = utils.MaxFrameSize
+
+// 可以在运行时修改（重新加载配置）
+func SetMaxFrameSize(sz int64) {
+	if sz > 0 {
+		atomic.StoreInt64(&maxFrameSize, sz)
+	}
+}
+
 func readMsgShared(c net.Conn) (buffer []byte, err error) {
 
 	var sz int64
@@ -15,15 +29,17 @@ func readMsgShared(c net.Conn) (buffer []byte, err error) {
 		return
 	}
 
-	buffer = make([]byte, sz)
-	n, err := c.Read(buffer)
-
-	if err != nil {
+	if max := atomic.LoadInt64(&maxFrameSize); sz <= 0 || sz > max {
+		err = fmt.Errorf("invalid frame size %d, max %d", sz, max)
 		return
 	}
 
-	if int64(n) != sz {
-		err = errors.New(fmt.Sprintf("Expected to read %d bytes, but only read %d", sz, n))
+	buffer = make([]byte, sz)
+	n, err := io.ReadFull(c, buffer)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New(fmt.Sprintf("Expected to read %d bytes, but only read %d", sz, n))
+		}
 		return
 	}
 
@@ -36,7 +52,7 @@ func ReadMsg(c net.Conn) (msg Message, tp string, err error) {
 		return
 	}
 
-	return Unpack(buffer)
+	return detectCodec(buffer).Unpack(buffer, nil)
 }
 
 func ReadMsgInto(c net.Conn, msg Message) (err error) {
@@ -44,11 +60,18 @@ func ReadMsgInto(c net.Conn, msg Message) (err error) {
 	if err != nil {
 		return
 	}
-	return UnpackInto(buffer, msg)
+	_, _, err = detectCodec(buffer).Unpack(buffer, msg)
+	return
 }
 
+// 使用JSON编码，握手报文总是使用这个
 func WriteMsg(c net.Conn, msg interface{}) (err error) {
-	buffer, err := Pack(msg)
+	return WriteMsgWith(c, JSON, msg)
+}
+
+// 使用握手时协商的编码
+func WriteMsgWith(c net.Conn, codec Codec, msg Message) (err error) {
+	buffer, err := codec.Pack(msg)
 	if err != nil {
 		return
 	}
@@ -66,20 +89,26 @@ func WriteMsg(c net.Conn, msg interface{}) (err error) {
 	return nil
 }
 
-func CheckResponse(conn *net.TCPConn, magic, req string) (err error) {
+func CheckResponse(conn net.Conn, magic, req string) (err error) {
+	_, err = ReadResponse(conn, magic, req)
+	return
+}
+
+// 读取并校验响应，对端拒绝时同时返回resp和err
+func ReadResponse(conn net.Conn, magic, req string) (resp *Response, err error) {
 	m, _, err := ReadMsg(conn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	resp, ok := m.(*Response)
 	if !ok {
 		err = fmt.Errorf("invalid resp type")
-		return
+		return nil, err
 	}
 	if resp.Magic != magic || resp.Request != req {
 		err = fmt.Errorf("invalid resp id/req [%s|%s]", resp.Magic, resp.Request)
-		return
+		return nil, err
 	}
 	if resp.Message != "" {
 		err = fmt.Errorf("response error [%s]", resp.Message)
@@ -87,3 +116,15 @@ func CheckResponse(conn *net.TCPConn, magic, req string) (err error) {
 	}
 	return
 }
+
+// 响应中协商的版本和特性，旧版本的proxy没有返回时只支持utils.MinVersion
+func ResponseCapabilities(resp *Response) *utils.Capabilities {
+	caps := &utils.Capabilities{
+		Version:  resp.Version,
+		Features: resp.Features,
+	}
+	if caps.Version == "" {
+		caps.Version = utils.MinVersion
+	}
+	return caps
+}
@@ -19,6 +19,7 @@ func init() {
 	TypeMap["Response"] = t((*Response)(nil))
 	TypeMap["Ping"] = t((*Ping)(nil))
 	TypeMap["Pong"] = t((*Pong)(nil))
+	TypeMap["MuxRequest"] = t((*MuxRequest)(nil))
 }
 
 type Message interface{}
@@ -30,9 +31,18 @@ type Envelope struct {
 
 // 新的上游管理通道
 type OutRequest struct {
-	Magic   string `json:"magic"`
-	Version string `json:"version"`
-	Type    string `json:"type"`
+	Magic      string   `json:"magic"`
+	Version    string   `json:"version"`
+	Type       string   `json:"type"`
+	Timestamp  int64    `json:"timestamp,omitempty"`   // 签名时间(秒)
+	Sign       string   `json:"sign,omitempty"`        // 签名，见utils.Sign
+	Lease      string   `json:"lease,omitempty"`       // 上次注册成功时proxy分配的租约
+	Weight     int      `json:"weight,omitempty"`      // 负载均衡的权重
+	Standby    bool     `json:"standby,omitempty"`     // 注册为备用，主out掉线时才接替
+	Codecs     []string `json:"codecs,omitempty"`      // 支持的编码，按优先级排列
+	MinVersion string   `json:"min_version,omitempty"` // 支持的最低版本，为空表示只支持Version
+	Features   []string `json:"features,omitempty"`    // 支持的特性
+	Compress   []string `json:"compress,omitempty"`    // 数据连接接受的压缩算法
 }
 
 // 请求新的上游数据通道
@@ -43,29 +53,58 @@ type NewDataRequest struct {
 
 // 数据通道生效请求
 type DataActiveRequest struct {
-	Magic string `json:"magic"`
-	Type  string `json:"type"`
+	Magic    string `json:"magic"`
+	Type     string `json:"type"`
+	Compress string `json:"compress,omitempty"` // in请求并且out接受的压缩算法，为空不压缩
+	Session  string `json:"session,omitempty"`  // in生成的会话ID，用于关联三端的日志
+	Target   string `json:"target,omitempty"`   // in请求的目标地址host:port，为空表示连接out配置的后端
 }
 
 // 新的上游数据通道
 type OutDataRequest struct {
-	Magic   string `json:"magic"`
-	Version string `json:"version"`
-	Type    string `json:"type"`
+	Magic      string   `json:"magic"`
+	Version    string   `json:"version"`
+	Type       string   `json:"type"`
+	Timestamp  int64    `json:"timestamp,omitempty"`   // 签名时间(秒)
+	Sign       string   `json:"sign,omitempty"`        // 签名，见utils.Sign
+	Lease      string   `json:"lease,omitempty"`       // 所属控制连接的租约
+	MinVersion string   `json:"min_version,omitempty"` // 支持的最低版本，为空表示只支持Version
+	Features   []string `json:"features,omitempty"`    // 支持的特性
 }
 
 // 新的下游数据通道
 type InRequest struct {
-	Magic   string `json:"magic"`
-	Version string `json:"version"`
-	Type    string `json:"type"`
+	Magic      string   `json:"magic"`
+	Version    string   `json:"version"`
+	Type       string   `json:"type"`
+	Timestamp  int64    `json:"timestamp,omitempty"`   // 签名时间(秒)
+	Sign       string   `json:"sign,omitempty"`        // 签名，见utils.Sign
+	MinVersion string   `json:"min_version,omitempty"` // 支持的最低版本，为空表示只支持Version
+	Features   []string `json:"features,omitempty"`    // 支持的特性
+	Compress   string   `json:"compress,omitempty"`    // 请求的压缩算法
+	Session    string   `json:"session,omitempty"`     // 会话ID，proxy转发给out
+	Target     string   `json:"target,omitempty"`      // 目标地址host:port（SOCKS5/HTTP CONNECT），proxy转发给out
+}
+
+// 新的多路复用连接，之后的数据按照mux的帧格式承载多个逻辑连接
+type MuxRequest struct {
+	Magic      string   `json:"magic"`
+	Version    string   `json:"version"`
+	MinVersion string   `json:"min_version,omitempty"` // 支持的最低版本，为空表示只支持Version
+	Features   []string `json:"features,omitempty"`    // 支持的特性
 }
 
 // 相应
 type Response struct {
-	Magic   string `json:"magic"`
-	Request string `json:"request"`
-	Message string `json:"message"`
+	Magic    string   `json:"magic"`
+	Request  string   `json:"request"`
+	Message  string   `json:"message"`
+	Lease    string   `json:"lease,omitempty"`    // OutRequest成功时分配的租约
+	Codec    string   `json:"codec,omitempty"`    // 协商的编码，之后的报文使用该编码，为空表示JSON
+	Version  string   `json:"version,omitempty"`  // 协商的版本
+	Features []string `json:"features,omitempty"` // 协商的特性
+	Compress string   `json:"compress,omitempty"` // InRequest生效的压缩算法，为空不压缩
+	Reason   string   `json:"reason,omitempty"`   // 拒绝的原因，例如no_tunnel、timeout，和reason标签一致
 }
 
 // A client or server may send this message periodically over
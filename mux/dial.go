@@ -0,0 +1,39 @@
+package mux
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/utils"
+)
+
+// 连接proxy并完成MuxRequest握手，返回发起方的session
+func Dial(host string, port uint16, conf *utils.TLSConfig) (*Session, error) {
+	conn, err := utils.Dial(host, port, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	uniqKey := utils.Magic()
+	msg.WriteMsg(conn, &msg.MuxRequest{
+		Magic:      uniqKey,
+		Version:    utils.Version,
+		MinVersion: utils.MinVersion,
+		Features:   utils.Features,
+	})
+
+	conn.SetReadDeadline(time.Now().Add(time.Duration(utils.HandshakeTimeout) * time.Millisecond))
+	resp, err := msg.ReadResponse(conn, uniqKey, "MuxRequest")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if !msg.ResponseCapabilities(resp).Has(utils.FeatureMux) {
+		conn.Close()
+		return nil, fmt.Errorf("proxy does not support mux")
+	}
+	return Client(conn), nil
+}
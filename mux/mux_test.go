@@ -0,0 +1,153 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func testPair(t *testing.T) (*Session, *Session) {
+	t.Helper()
+	a, b := net.Pipe()
+	client, server := Client(a), Server(b)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	client, server := testPair(t)
+
+	// 超过接收窗口的数据，依赖窗口更新才能发完
+	data := bytes.Repeat([]byte("0123456789"), initialWindow/5)
+	go func() {
+		st, err := server.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(st, st)
+		st.Close()
+	}()
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		st.Write(data)
+		st.CloseWrite()
+	}()
+	got, err := io.ReadAll(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes, expect %d", len(got), len(data))
+	}
+	st.Close()
+}
+
+func TestStreamDeadline(t *testing.T) {
+	client, server := testPair(t)
+	go server.Accept()
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = st.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := testPair(t)
+	go server.Accept()
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if _, err := st.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read should fail after session closed")
+	}
+	select {
+	case <-client.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("client session not closed")
+	}
+	if _, err := client.Open(); err != ErrSessionClosed {
+		t.Fatalf("expect ErrSessionClosed, got %v", err)
+	}
+}
+
+func frame(cmd byte, id uint32, data []byte) []byte {
+	buf := make([]byte, headerLen+len(data))
+	buf[0] = cmd
+	binary.BigEndian.PutUint32(buf[1:], id)
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(data)))
+	copy(buf[headerLen:], data)
+	return buf
+}
+
+// 不遵守窗口的对端，stream被重置，缓存不超过窗口
+func TestStreamWindowExceeded(t *testing.T) {
+	a, b := net.Pipe()
+	server := Server(b)
+	defer server.Close()
+	defer a.Close()
+
+	// 读取server回复的帧，避免写阻塞
+	frames := make(chan byte, 16)
+	go func() {
+		header := make([]byte, headerLen)
+		for {
+			if _, err := io.ReadFull(a, header); err != nil {
+				close(frames)
+				return
+			}
+			io.CopyN(io.Discard, a, int64(binary.BigEndian.Uint32(header[5:])))
+			frames <- header[0]
+		}
+	}()
+
+	if _, err := a.Write(frame(cmdSYN, 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	st, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, maxFrameLen)
+	for i := 0; i < initialWindow/maxFrameLen+1; i++ {
+		if _, err := a.Write(frame(cmdPSH, 1, chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case cmd := <-frames:
+		if cmd != cmdRST {
+			t.Fatalf("expect RST, got cmd %d", cmd)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream not reset")
+	}
+	st.lock.Lock()
+	buffered := st.buf.Len()
+	st.lock.Unlock()
+	if buffered > initialWindow {
+		t.Fatalf("buffered %d bytes beyond window", buffered)
+	}
+	if server.NumStreams() != 0 {
+		t.Fatal("stream not removed")
+	}
+}
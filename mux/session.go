@@ -0,0 +1,245 @@
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// 在一个连接上承载多路逻辑stream，类似yamux/smux
+//
+// 帧格式：cmd(1字节) + stream id(4字节) + 长度(4字节) + 数据，整数使用大端
+const (
+	cmdSYN byte = iota // 新建stream
+	cmdPSH             // 数据
+	cmdFIN             // 对端不再发送数据
+	cmdRST             // 异常关闭
+	cmdUPD             // 窗口更新，数据为4字节的增量
+)
+
+const (
+	headerLen     = 9
+	maxFrameLen   = 32 * 1024  // 单个帧的最大数据长度
+	initialWindow = 256 * 1024 // 每个stream的接收窗口
+	acceptBacklog = 1024       // 等待Accept的stream上限
+)
+
+var (
+	ErrSessionClosed = errors.New("mux session closed")
+	ErrStreamReset   = errors.New("mux stream reset")
+)
+
+type Session struct {
+	conn net.Conn
+
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	streams map[uint32]*Stream
+	nextId  uint32
+
+	accepts chan *Stream
+	die     chan struct{}
+	dieOnce sync.Once
+}
+
+// 发起方使用奇数的stream id
+func Client(conn net.Conn) *Session {
+	return newSession(conn, 1)
+}
+
+// 接收方使用偶数的stream id
+func Server(conn net.Conn) *Session {
+	return newSession(conn, 2)
+}
+
+func newSession(conn net.Conn, nextId uint32) *Session {
+	s := &Session{
+		conn:    conn,
+		streams: make(map[uint32]*Stream),
+		nextId:  nextId,
+		accepts: make(chan *Stream, acceptBacklog),
+		die:     make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// 新建一个stream
+func (this *Session) Open() (*Stream, error) {
+	this.lock.Lock()
+	if this.IsClosed() {
+		this.lock.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := this.nextId
+	this.nextId += 2
+	st := newStream(id, this)
+	this.streams[id] = st
+	this.lock.Unlock()
+
+	if err := this.writeFrame(cmdSYN, id, nil); err != nil {
+		this.remove(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// 等待对端新建的stream
+func (this *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-this.accepts:
+		return st, nil
+	case <-this.die:
+		return nil, ErrSessionClosed
+	}
+}
+
+func (this *Session) Close() error {
+	var err error
+	this.dieOnce.Do(func() {
+		close(this.die)
+		err = this.conn.Close()
+
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		for k, v := range this.streams {
+			v.notifyReset()
+			delete(this.streams, k)
+		}
+	})
+	return err
+}
+
+func (this *Session) IsClosed() bool {
+	select {
+	case <-this.die:
+		return true
+	default:
+		return false
+	}
+}
+
+// session关闭时被close
+func (this *Session) CloseChan() <-chan struct{} {
+	return this.die
+}
+
+func (this *Session) NumStreams() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.streams)
+}
+
+func (this *Session) LocalAddr() net.Addr {
+	return this.conn.LocalAddr()
+}
+
+func (this *Session) RemoteAddr() net.Addr {
+	return this.conn.RemoteAddr()
+}
+
+func (this *Session) remove(id uint32) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.streams, id)
+}
+
+func (this *Session) get(id uint32) *Stream {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.streams[id]
+}
+
+func (this *Session) writeFrame(cmd byte, id uint32, data []byte) error {
+	buf := make([]byte, headerLen+len(data))
+	buf[0] = cmd
+	binary.BigEndian.PutUint32(buf[1:], id)
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(data)))
+	copy(buf[headerLen:], data)
+
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+	if this.IsClosed() {
+		return ErrSessionClosed
+	}
+	if _, err := this.conn.Write(buf); err != nil {
+		this.Close()
+		return err
+	}
+	return nil
+}
+
+func (this *Session) recvLoop() {
+	defer this.Close()
+
+	header := make([]byte, headerLen)
+	for {
+		if _, err := io.ReadFull(this.conn, header); err != nil {
+			return
+		}
+		cmd := header[0]
+		id := binary.BigEndian.Uint32(header[1:])
+		length := binary.BigEndian.Uint32(header[5:])
+		if length > maxFrameLen {
+			logs.Warn("invalid mux frame length", "length", length, "remote", this.conn.RemoteAddr().String())
+			return
+		}
+
+		var data []byte
+		if length > 0 {
+			data = make([]byte, length)
+			if _, err := io.ReadFull(this.conn, data); err != nil {
+				return
+			}
+		}
+
+		switch cmd {
+		case cmdSYN:
+			this.lock.Lock()
+			if _, ok := this.streams[id]; ok {
+				this.lock.Unlock()
+				continue
+			}
+			st := newStream(id, this)
+			this.streams[id] = st
+			this.lock.Unlock()
+
+			select {
+			case this.accepts <- st:
+			default:
+				// 积压太多，拒绝
+				this.remove(id)
+				this.writeFrame(cmdRST, id, nil)
+			}
+		case cmdPSH:
+			if st := this.get(id); st != nil && !st.pushData(data) {
+				// 对端没有遵守流控窗口，缓存会无限增长
+				logs.Warn("mux stream window exceeded", "stream", id, "remote", this.conn.RemoteAddr().String())
+				this.remove(id)
+				st.notifyReset()
+				this.writeFrame(cmdRST, id, nil)
+			}
+		case cmdFIN:
+			if st := this.get(id); st != nil {
+				st.notifyFin()
+			}
+		case cmdRST:
+			if st := this.get(id); st != nil {
+				this.remove(id)
+				st.notifyReset()
+			}
+		case cmdUPD:
+			if st := this.get(id); st != nil && len(data) == 4 {
+				st.updateWindow(binary.BigEndian.Uint32(data))
+			}
+		default:
+			logs.Warn("invalid mux frame cmd", "cmd", cmd, "remote", this.conn.RemoteAddr().String())
+			return
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 超时错误，实现net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "mux stream i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// 逻辑连接，实现net.Conn
+type Stream struct {
+	id      uint32
+	session *Session
+
+	lock          sync.Mutex
+	buf           bytes.Buffer // 已接收未读取的数据
+	consumed      uint32       // 已读取但还没有通知对端的字节数
+	recvWindow    uint32       // 本端还允许对端发送的字节数，超出时重置stream
+	sendWindow    uint32       // 对端还能接收的字节数
+	recvFin       bool         // 对端不再发送或者本端关闭了读
+	sendFin       bool         // 本端不再发送
+	reset         bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readEvent  chan struct{}
+	writeEvent chan struct{}
+}
+
+func newStream(id uint32, session *Session) *Stream {
+	return &Stream{
+		id:         id,
+		session:    session,
+		recvWindow: initialWindow,
+		sendWindow: initialWindow,
+		readEvent:  make(chan struct{}, 1),
+		writeEvent: make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func waitEvent(ch chan struct{}, deadline time.Time, die <-chan struct{}) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return timeoutError{}
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return timeoutError{}
+	case <-die:
+		return ErrSessionClosed
+	}
+}
+
+func (this *Stream) Id() uint32 {
+	return this.id
+}
+
+func (this *Stream) Read(b []byte) (int, error) {
+	for {
+		this.lock.Lock()
+		if this.buf.Len() > 0 {
+			n, _ := this.buf.Read(b)
+			this.consumed += uint32(n)
+			var upd uint32
+			if this.consumed >= initialWindow/2 {
+				upd = this.consumed
+				this.consumed = 0
+				this.recvWindow += upd
+			}
+			this.lock.Unlock()
+
+			if upd > 0 {
+				data := make([]byte, 4)
+				binary.BigEndian.PutUint32(data, upd)
+				this.session.writeFrame(cmdUPD, this.id, data)
+			}
+			return n, nil
+		}
+		if this.reset {
+			this.lock.Unlock()
+			return 0, ErrStreamReset
+		}
+		if this.recvFin {
+			this.lock.Unlock()
+			return 0, io.EOF
+		}
+		deadline := this.readDeadline
+		this.lock.Unlock()
+
+		if err := waitEvent(this.readEvent, deadline, this.session.die); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (this *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		this.lock.Lock()
+		if this.reset {
+			this.lock.Unlock()
+			return written, ErrStreamReset
+		}
+		if this.sendFin {
+			this.lock.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if this.sendWindow == 0 {
+			deadline := this.writeDeadline
+			this.lock.Unlock()
+			if err := waitEvent(this.writeEvent, deadline, this.session.die); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := len(b) - written
+		if n > maxFrameLen {
+			n = maxFrameLen
+		}
+		if uint32(n) > this.sendWindow {
+			n = int(this.sendWindow)
+		}
+		this.sendWindow -= uint32(n)
+		this.lock.Unlock()
+
+		if err := this.session.writeFrame(cmdPSH, this.id, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// 不再发送，对端读到EOF
+func (this *Stream) CloseWrite() error {
+	this.lock.Lock()
+	if this.sendFin || this.reset {
+		this.lock.Unlock()
+		return nil
+	}
+	this.sendFin = true
+	this.lock.Unlock()
+
+	notify(this.writeEvent)
+	return this.session.writeFrame(cmdFIN, this.id, nil)
+}
+
+// 不再接收，阻塞的Read返回EOF
+func (this *Stream) CloseRead() error {
+	this.lock.Lock()
+	this.recvFin = true
+	this.buf.Reset()
+	this.lock.Unlock()
+
+	notify(this.readEvent)
+	return nil
+}
+
+func (this *Stream) Close() error {
+	err := this.CloseWrite()
+	this.CloseRead()
+	this.session.remove(this.id)
+	return err
+}
+
+func (this *Stream) LocalAddr() net.Addr {
+	return this.session.LocalAddr()
+}
+
+func (this *Stream) RemoteAddr() net.Addr {
+	return this.session.RemoteAddr()
+}
+
+// 承载的底层连接，用于获取对端证书等信息
+func (this *Stream) NetConn() net.Conn {
+	return this.session.conn
+}
+
+func (this *Stream) SetDeadline(t time.Time) error {
+	this.SetReadDeadline(t)
+	return this.SetWriteDeadline(t)
+}
+
+func (this *Stream) SetReadDeadline(t time.Time) error {
+	this.lock.Lock()
+	this.readDeadline = t
+	this.lock.Unlock()
+
+	notify(this.readEvent)
+	return nil
+}
+
+func (this *Stream) SetWriteDeadline(t time.Time) error {
+	this.lock.Lock()
+	this.writeDeadline = t
+	this.lock.Unlock()
+
+	notify(this.writeEvent)
+	return nil
+}
+
+// 对端发送的数据超出了接收窗口时返回false，不缓存这部分数据
+func (this *Stream) pushData(data []byte) bool {
+	this.lock.Lock()
+	if uint32(len(data)) > this.recvWindow {
+		this.lock.Unlock()
+		return false
+	}
+	this.recvWindow -= uint32(len(data))
+	if !this.recvFin {
+		this.buf.Write(data)
+	}
+	this.lock.Unlock()
+
+	notify(this.readEvent)
+	return true
+}
+
+func (this *Stream) notifyFin() {
+	this.lock.Lock()
+	this.recvFin = true
+	this.lock.Unlock()
+
+	notify(this.readEvent)
+}
+
+func (this *Stream) notifyReset() {
+	this.lock.Lock()
+	this.reset = true
+	this.lock.Unlock()
+
+	notify(this.readEvent)
+	notify(this.writeEvent)
+}
+
+func (this *Stream) updateWindow(n uint32) {
+	this.lock.Lock()
+	this.sendWindow += n
+	this.lock.Unlock()
+
+	notify(this.writeEvent)
+}
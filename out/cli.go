@@ -0,0 +1,138 @@
+package out
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/metrics"
+	"github.com/qjw/proxy/utils"
+)
+
+// 命令行参数，常用字段显式指定时覆盖配置文件和环境变量
+type cliFlags struct {
+	fs                *flag.FlagSet
+	config            *string
+	checkOnly         *bool
+	server            *string
+	token             *string
+	retryInterval     *int
+	heartbeatInterval *int
+	heartbeatTimeout  *int
+	metrics           *string
+	logLevel          *string
+}
+
+func newFlags(name string) *cliFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &cliFlags{
+		fs:                fs,
+		config:            fs.String("config", "", "配置文件"),
+		checkOnly:         fs.Bool("check-config", false, "只校验配置文件，有错误时以非0退出"),
+		server:            fs.String("server", "", "proxy的地址host:port，覆盖所有network"),
+		token:             fs.String("token", "", "认证密钥，覆盖所有network"),
+		retryInterval:     fs.Int("retry-interval", 0, "掉线重试间隔(毫秒）"),
+		heartbeatInterval: fs.Int("heartbeat-interval", 0, "心跳检测间隔(毫秒）"),
+		heartbeatTimeout:  fs.Int("heartbeat-timeout", 0, "心跳超时(毫秒）"),
+		metrics:           fs.String("metrics", "", "监控指标的监听地址"),
+		logLevel:          fs.String("log-level", "", "日志级别debug/info/warn/error"),
+	}
+}
+
+func (this *cliFlags) apply(conf *Config) error {
+	var err error
+	this.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			host, port, e := utils.SplitHostPort(*this.server)
+			if e != nil {
+				err = fmt.Errorf("invalid --server: %s", e.Error())
+				return
+			}
+			for _, v := range conf.Networks {
+				v.ServerHost, v.ServerPort = host, port
+			}
+		case "token":
+			for _, v := range conf.Networks {
+				v.Token = *this.token
+			}
+		case "retry-interval":
+			conf.RetryInterval = *this.retryInterval
+		case "heartbeat-interval":
+			conf.HeartbeatInterval = *this.heartbeatInterval
+		case "heartbeat-timeout":
+			conf.HeartbeatTimeout = *this.heartbeatTimeout
+		case "metrics":
+			conf.Metrics = *this.metrics
+		case "log-level":
+			if conf.Log == nil {
+				conf.Log = logs.DefaultConfig()
+			}
+			conf.Log.Level = *this.logLevel
+		}
+	})
+	return err
+}
+
+// proxy out子命令，返回进程的退出码
+func Main(name string, args []string) int {
+	f := newFlags(name)
+	if err := f.fs.Parse(args); err != nil {
+		return 2
+	}
+	load := func() (*Config, error) {
+		return LoadConfig(*f.config, f.apply)
+	}
+
+	conf, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *f.checkOnly {
+		fmt.Println("config ok")
+		return 0
+	}
+	if err := logs.Setup(conf.Log, "out"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logs.Info("loaded config", "config", utils.RedactConfig(conf))
+
+	s, err := New(conf)
+	if err != nil {
+		logs.Error("create out failed", "error", err)
+		return 1
+	}
+	logs.Info("starting the server", "version", utils.Version)
+	utils.RandomSeed()
+	if conf.Metrics != "" {
+		go metrics.Serve(conf.Metrics)
+	}
+	s.Start()
+
+	// 信号 和谐的退出，SIGHUP重新加载配置
+	exitMng := utils.NewExitManager()
+	exitMng.OnReload(func() {
+		if *f.config == "" {
+			logs.Error("reload config failed", "error", "started without config file")
+			return
+		}
+		conf, err := load()
+		if err == nil {
+			old := s.Config().Log
+			if err = s.Reload(conf); err == nil {
+				err = logs.Update(old, conf.Log, "out")
+			}
+		}
+		if err != nil {
+			logs.Error("reload config failed", "error", err)
+		}
+	})
+	go exitMng.Run(s.Shutdown)
+
+	// 等待结束
+	s.Wait()
+	return 0
+}
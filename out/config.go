@@ -1,25 +1,38 @@
-package main
+package out
 
 import (
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/msg"
 	"github.com/qjw/proxy/utils"
 )
 
 type Network struct {
-	ServerHost  string `json:"server_host" binding:"required"`  // 服务器域名/IP
-	ServerPort  uint16 `json:"server_port" binding:"required"`  // 服务器端口
-	BackendHost string `json:"backend_host" binding:"required"` // 后端域名/IP
-	BackendPort uint16 `json:"backend_port" binding:"required"` // 后端端口
-	Topic       string `json:"topic"`                           // 请求类别
+	ServerHost  string           `json:"server_host" binding:"required"` // 服务器域名/IP
+	ServerPort  uint16           `json:"server_port" binding:"required"` // 服务器端口
+	BackendHost string           `json:"backend_host"`                   // 后端域名/IP，只做exit时可以为空
+	BackendPort uint16           `json:"backend_port"`                   // 后端端口
+	Topic       string           `json:"topic"`                          // 请求类别
+	TLS         *utils.TLSConfig `json:"tls"`                            // 证书配置，为空则使用明文
+	Token       string           `json:"token"`                          // 认证密钥，和proxy上该Topic的token一致
+	Weight      int              `json:"weight"`                         // 负载均衡的权重，proxy上该Topic使用weighted策略时生效
+	Standby     bool             `json:"standby"`                        // 注册为备用，主out掉线时proxy立即切换过来
+	Codecs      []string         `json:"codecs"`                         // 支持的编码(binary/json)，按优先级排列，默认全部
+	Mux         bool             `json:"mux"`                            // 数据连接复用一个到proxy的连接
+	Compress    []string         `json:"compress"`                       // 接受in请求的压缩算法，不配置接受全部，[]不压缩
+	Exit        []*ExitRule      `json:"exit"`                           // 允许直接连接的目标地址，配置之后接受in带上的目标地址
 }
 
 type Config struct {
-	Networks          []*Network `json:"networks"`
-	RetryInterval     int        `json:"retry_interval"`     // 掉线重试间隔(毫秒）
-	HeartbeatInterval int        `json:"heartbeat_interval"` // 心跳检测间隔(毫秒）
-	HeartbeatTimeout  int        `json:"heartbeat_timeout"`  // 心跳超时(毫秒）
+	Networks          []*Network         `json:"networks"`
+	RetryInterval     int                `json:"retry_interval"`     // 掉线重试间隔(毫秒）
+	HeartbeatInterval int                `json:"heartbeat_interval"` // 心跳检测间隔(毫秒）
+	HeartbeatTimeout  int                `json:"heartbeat_timeout"`  // 心跳超时(毫秒）
+	Metrics           string             `json:"metrics"`            // 监控指标(/metrics)的监听地址，为空不开启
+	Log               *logs.Config       `json:"log"`                // 日志配置
+	Access            *logs.AccessConfig `json:"access_log"`         // 访问日志，为空不开启
 }
 
-func defaultConfig() *Config {
+func DefaultConfig() *Config {
 	return &Config{
 		Networks: []*Network{
 			{
@@ -33,18 +46,130 @@ func defaultConfig() *Config {
 		RetryInterval:     utils.RetryInterval,
 		HeartbeatInterval: utils.HeartbeatInterval,
 		HeartbeatTimeout:  utils.HeartbeatTimeout,
+		Log:               logs.DefaultConfig(),
 	}
 }
 
-func parseConfig(path string) *Config {
-	conf := defaultConfig()
-	if err := utils.JsonConfToStruct(path, conf); err != nil {
-		panic(err)
+// 接受的压缩算法
+func (this *Network) CompressMethods() []string {
+	if this.Compress == nil {
+		return utils.CompressMethods
 	}
+	methods := make([]string, 0)
+	for _, v := range this.Compress {
+		if utils.SupportCompress(v) {
+			methods = append(methods, v)
+		}
+	}
+	return methods
+}
+
+func (this *Network) AcceptCompress(method string) bool {
+	for _, v := range this.CompressMethods() {
+		if v == method {
+			return true
+		}
+	}
+	return false
+}
+
+// 依次使用默认值、配置文件（path为空则跳过）、环境变量(OUT_*)和override（例如命令行参数，可以为空），最后校验
+func LoadConfig(path string, override func(conf *Config) error) (*Config, error) {
+	conf := DefaultConfig()
+	if path != "" {
+		// 默认的network只在配置文件没有networks时使用，否则networks[0]会继承默认的字段
+		defaults := conf.Networks
+		conf.Networks = nil
+		if err := utils.ConfToStruct(path, conf); err != nil {
+			return nil, err
+		}
+		if conf.Networks == nil {
+			conf.Networks = defaults
+		}
+	}
+	if err := utils.EnvOverride("OUT", conf); err != nil {
+		return nil, err
+	}
+	if override != nil {
+		if err := override(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
 
-	//	if err := kelly.Validate(conf); err != nil {
-	//		panic(err)
-	//	}
+// 校验配置，返回所有的错误（utils.ConfigErrors）
+func (this *Config) Validate() error {
+	v := utils.NewValidator()
+	v.Required("", this)
+	if len(this.Networks) < 1 || len(this.Networks) > 16 {
+		v.Errorf("networks", "network count must be in 1-16, got %d", len(this.Networks))
+	}
+
+	for i, n := range this.Networks {
+		path := utils.IndexPath("networks", i)
+		if n == nil {
+			v.Errorf(path, "null network")
+			continue
+		}
+		v.Host(utils.JoinPath(path, "server_host"), n.ServerHost)
+		v.Host(utils.JoinPath(path, "backend_host"), n.BackendHost)
+		if len(n.Exit) == 0 {
+			if n.BackendHost == "" {
+				v.Errorf(utils.JoinPath(path, "backend_host"), "required")
+			}
+			if n.BackendPort == 0 {
+				v.Errorf(utils.JoinPath(path, "backend_port"), "required")
+			}
+		} else if (n.BackendHost == "") != (n.BackendPort == 0) {
+			v.Errorf(path, "backend_host and backend_port must be configured together")
+		}
+		for j, r := range n.Exit {
+			rpath := utils.IndexPath(utils.JoinPath(path, "exit"), j)
+			if r == nil {
+				v.Errorf(rpath, "null rule")
+				continue
+			}
+			for k, h := range r.Hosts {
+				if !utils.ValidHostPattern(h) {
+					v.Errorf(utils.IndexPath(utils.JoinPath(rpath, "hosts"), k), "invalid host [%s]", h)
+				}
+			}
+			for k, p := range r.Ports {
+				if p == 0 {
+					v.Errorf(utils.IndexPath(utils.JoinPath(rpath, "ports"), k), "invalid port 0")
+				}
+			}
+		}
+		v.Topic(utils.JoinPath(path, "topic"), n.Topic, true)
+		v.TLS(utils.JoinPath(path, "tls"), n.TLS, false)
+		if n.Weight < 0 {
+			v.Errorf(utils.JoinPath(path, "weight"), "must not be negative")
+		}
+		for j, c := range n.Codecs {
+			v.OneOf(utils.IndexPath(utils.JoinPath(path, "codecs"), j), c, msg.CodecNames()...)
+		}
+		for j, c := range n.Compress {
+			v.OneOf(utils.IndexPath(utils.JoinPath(path, "compress"), j), c, utils.CompressDeflate)
+		}
+	}
 
-	return conf
+	if this.RetryInterval <= 0 {
+		v.Errorf("retry_interval", "must be positive")
+	}
+	if this.HeartbeatInterval <= 0 {
+		v.Errorf("heartbeat_interval", "must be positive")
+	}
+	if this.HeartbeatTimeout <= this.HeartbeatInterval {
+		v.Errorf("heartbeat_timeout", "must be greater than heartbeat_interval (%d)", this.HeartbeatInterval)
+	}
+	if this.Metrics != "" {
+		v.Addr("metrics", this.Metrics)
+	}
+	v.Log("log", this.Log)
+	v.Access("access_log", this.Access)
+	return v.Err()
 }
@@ -0,0 +1,89 @@
+package out
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/qjw/proxy/utils"
+)
+
+func writeConf(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.json")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 配置文件中的network不继承默认network的字段
+func TestLoadConfigNetworks(t *testing.T) {
+	conf, err := LoadConfig(writeConf(t, `{"networks": [{"server_host": "10.0.0.1", "server_port": 40001, "topic": "exit", "exit": [{}]}]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := conf.Networks[0]
+	if n.BackendHost != "" || n.BackendPort != 0 {
+		t.Fatalf("exit-only network inherits backend %s:%d", n.BackendHost, n.BackendPort)
+	}
+
+	if _, err := LoadConfig(writeConf(t, `{"networks": [{"server_host": "10.0.0.1", "server_port": 40001, "topic": "db"}]}`), nil); err == nil {
+		t.Fatal("network without backend and exit should be invalid")
+	}
+
+	// 没有networks时使用默认的network
+	conf, err = LoadConfig(writeConf(t, `{"retry_interval": 1000}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Networks) != 1 || conf.Networks[0].BackendPort != 40003 || conf.RetryInterval != 1000 {
+		t.Fatalf("default network not applied: %+v", conf.Networks)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	_, err := LoadConfig(writeConf(t, `{
+		"networks": [
+			{"server_port": 40001, "topic": "db"},
+			{"server_host": "p", "server_port": 40001, "backend_host": "b", "topic": "#", "weight": -1,
+				"codecs": ["xml"], "compress": ["gzip"], "exit": [{"hosts": ["bad host"], "ports": [0]}, null]}
+		],
+		"retry_interval": 0,
+		"heartbeat_interval": 2000,
+		"heartbeat_timeout": 1000,
+		"access_log": {"format": "csv"}
+	}`), nil)
+
+	expect := []string{
+		"access_log.format",
+		"heartbeat_timeout",
+		"networks[0].backend_host",
+		"networks[0].backend_port",
+		"networks[0].server_host",
+		"networks[1]",
+		"networks[1].codecs[0]",
+		"networks[1].compress[0]",
+		"networks[1].exit[0].hosts[0]",
+		"networks[1].exit[0].ports[0]",
+		"networks[1].exit[1]",
+		"networks[1].topic",
+		"networks[1].weight",
+		"retry_interval",
+	}
+	var errs utils.ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expect ConfigErrors, got %v", err)
+	}
+	paths := make([]string, 0, len(errs))
+	for _, v := range errs {
+		paths = append(paths, v.Path)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("got %v, expect %v", paths, expect)
+	}
+}
@@ -0,0 +1,63 @@
+package out
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/qjw/proxy/utils"
+)
+
+// exit模式允许连接的目标地址
+type ExitRule struct {
+	Hosts []string `json:"hosts"` // 目标主机：域名、*.example.com、IP或者CIDR，为空匹配所有
+	Ports []uint16 `json:"ports"` // 目标端口，为空匹配所有
+}
+
+func (this *ExitRule) Match(host string, port uint16) bool {
+	return utils.MatchAddr(this.Hosts, this.Ports, host, port)
+}
+
+func (this *Network) allowExit(host string, port uint16) bool {
+	for _, v := range this.Exit {
+		if v.Match(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查in请求的目标地址，返回实际连接的地址
+//
+// 先按照名称匹配；域名没有匹配时解析，用解析出的IP匹配IP/CIDR规则，
+// 并且直接连接匹配的IP，避免连接时再次解析得到不同的地址
+func (this *Network) ExitAddr(target string) (string, error) {
+	if len(this.Exit) == 0 {
+		return "", fmt.Errorf("exit mode not enabled")
+	}
+	if err := utils.CheckTarget(target); err != nil {
+		return "", err
+	}
+	host, port, _ := utils.SplitHostPort(target)
+	if this.allowExit(host, port) {
+		return target, nil
+	}
+	if net.ParseIP(host) != nil {
+		return "", fmt.Errorf("target [%s] not allowed", target)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(utils.HandshakeTimeout)*time.Millisecond)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("target [%s] not allowed", target)
+	}
+	for _, v := range addrs {
+		if this.allowExit(v.IP.String(), port) {
+			return net.JoinHostPort(v.IP.String(), strconv.Itoa(int(port))), nil
+		}
+	}
+	return "", fmt.Errorf("target [%s] not allowed", target)
+}
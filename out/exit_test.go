@@ -0,0 +1,52 @@
+package out
+
+import (
+	"testing"
+)
+
+func TestExitAddr(t *testing.T) {
+	network := &Network{
+		Exit: []*ExitRule{
+			{Hosts: []string{"*.internal.example.com"}, Ports: []uint16{443}},
+			{Hosts: []string{"10.0.0.0/8"}},
+			{Hosts: []string{"127.0.0.1"}, Ports: []uint16{22}},
+		},
+	}
+	cases := []struct {
+		target string
+		addr   string // 为空表示拒绝
+	}{
+		{"api.internal.example.com:443", "api.internal.example.com:443"},
+		{"API.internal.example.com.:443", "API.internal.example.com.:443"},
+		{"api.internal.example.com:80", ""},
+		{"internal.example.com:443", ""},
+		{"10.1.2.3:3306", "10.1.2.3:3306"},
+		{"11.1.2.3:3306", ""},
+		{"127.0.0.1:22", "127.0.0.1:22"},
+		{"127.0.0.1:23", ""},
+		// 域名没有匹配时解析，直接连接匹配的IP
+		{"localhost:22", "127.0.0.1:22"},
+		{"localhost:23", ""},
+		{"no-such-host.invalid:22", ""},
+		{"bad host:22", ""},
+		{"10.1.2.3", ""},
+		{"10.1.2.3:0", ""},
+	}
+	for _, c := range cases {
+		addr, err := network.ExitAddr(c.target)
+		if c.addr == "" {
+			if err == nil {
+				t.Errorf("ExitAddr(%s) = %s, expect refused", c.target, addr)
+			}
+			continue
+		}
+		if err != nil || addr != c.addr {
+			t.Errorf("ExitAddr(%s) = %s, %v, expect %s", c.target, addr, err, c.addr)
+		}
+	}
+
+	// 没有配置exit时不接受目标地址
+	if _, err := (&Network{}).ExitAddr("10.1.2.3:22"); err == nil {
+		t.Error("exit mode not enabled should refuse targets")
+	}
+}
@@ -0,0 +1,94 @@
+package out
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// 所有network对应的sessionGroup，重新加载配置时按照network增删
+type groupMng struct {
+	sync.Mutex
+	o      *Out
+	groups map[*sessionGroup]*Network
+	closed bool
+	wait   sync.WaitGroup
+}
+
+func NewGroupMng(o *Out) *groupMng {
+	return &groupMng{
+		o:      o,
+		groups: make(map[*sessionGroup]*Network),
+	}
+}
+
+// 按照配置启动新增的network，停止删除的network，配置不变的保持原样；
+// 修改过的network视为先删除再新增，会重新注册，它的数据连接随之关闭
+func (this *groupMng) Apply(networks []*Network) {
+	this.Lock()
+	defer this.Unlock()
+	if this.closed {
+		return
+	}
+
+	unchanged := make(map[*sessionGroup]bool)
+	var added []*Network
+	for _, network := range networks {
+		found := false
+		for s, v := range this.groups {
+			if !unchanged[s] && reflect.DeepEqual(v, network) {
+				unchanged[s] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, network)
+		}
+	}
+
+	// 先启动新的，避免WaitGroup归零导致程序退出
+	for _, network := range added {
+		s := NewSessionGroup(this.o)
+		this.groups[s] = network
+		this.wait.Add(1)
+		go func(s *sessionGroup, network *Network) {
+			defer this.wait.Done()
+			s.Run(network)
+		}(s, network)
+		logs.Info("network started", "topic", network.Topic)
+	}
+
+	for s, network := range this.groups {
+		if unchanged[s] || contains(added, network) {
+			continue
+		}
+		logs.Info("network stopped", "topic", network.Topic)
+		s.Shutdown()
+		delete(this.groups, s)
+	}
+}
+
+func contains(list []*Network, network *Network) bool {
+	for _, v := range list {
+		if v == network {
+			return true
+		}
+	}
+	return false
+}
+
+// 停止所有sessionGroup，之后的Apply不再生效
+func (this *groupMng) Shutdown() {
+	this.Lock()
+	defer this.Unlock()
+	this.closed = true
+	for s := range this.groups {
+		s.Shutdown()
+	}
+}
+
+func (this *groupMng) Wait() {
+	this.wait.Wait()
+}
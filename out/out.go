@@ -1,28 +1,78 @@
-package main
+package out
 
 import (
-	"encoding/json"
-	"flag"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/qjw/proxy/logs"
 	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/mux"
 	"github.com/qjw/proxy/utils"
 )
 
-var (
-	gConfig *Config = nil
-)
-
 type connection struct {
 	c            *connectionMng
-	svr          *net.TCPConn
-	cli          *net.TCPConn
+	svr          net.Conn
+	cli          net.Conn
 	shutdown     *utils.Shutdown
 	loopShutdown *utils.Shutdown
 	network      *Network
+	codec        msg.Codec
+	id           string       // 唯一标识
+	log          *logs.Logger // 附带conn、topic字段
+
+	lock     sync.Mutex
+	session  string    // in生成的会话ID，收到DataActiveRequest之后才有
+	started  time.Time // 收到DataActiveRequest的时间
+	target   string    // in请求的目标地址
+	reason   string    // 结束的原因，只记录第一个
+	err      string
+	bytesIn  int64 // proxy发往后端的字节数
+	bytesOut int64 // 后端发往proxy的字节数
+}
+
+// 记录结束的原因，已经记录过则忽略
+func (this *connection) setReason(reason, err string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.reason == "" {
+		this.reason = reason
+		this.err = err
+	}
+}
+
+// 带上原因结束connection
+func (this *connection) Terminate(reason, err string) {
+	this.setReason(reason, err)
+	this.Shutdown()
+}
+
+// 写访问日志，空闲（没有被激活）的数据连接不记录
+func (this *connection) access() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.started.IsZero() {
+		return
+	}
+
+	record := &logs.AccessRecord{
+		Start:    this.started,
+		End:      time.Now(),
+		Session:  this.session,
+		Topic:    this.network.Topic,
+		Client:   this.cli.RemoteAddr().String(),
+		BytesIn:  this.bytesIn,
+		BytesOut: this.bytesOut,
+		Reason:   this.reason,
+		Error:    this.err,
+		Target:   this.target,
+	}
+	if this.svr != nil {
+		record.Upstream = this.svr.RemoteAddr().String()
+	}
+	this.c.access.Write(record)
 }
 
 func (this *connection) loop() {
@@ -37,22 +87,28 @@ func (this *connection) loop() {
 	// 等待就绪
 	m, tp, err := msg.ReadMsg(this.cli)
 	if err != nil {
-		fmt.Printf("read message error [%s]\n", err.Error())
+		this.log.Debug("read DataActiveRequest failed", "error", err)
 		return
 	}
 
 	if tp != "DataActiveRequest" {
-		fmt.Printf("invalid out resp type\n")
+		this.log.Warn("invalid request", "request", tp)
 		return
 	}
 
 	req, ok := m.(*msg.DataActiveRequest)
 	if !ok {
-		fmt.Print("invalid OutRequest type\n")
+		this.log.Warn("invalid DataActiveRequest type")
 		return
 	}
-	if req.Type != this.network.Topic {
-		fmt.Printf("invalid Topic %s\n", req.Type)
+	log := this.log.With("session", req.Session, "magic", req.Magic)
+	this.lock.Lock()
+	this.session = req.Session
+	this.started = time.Now()
+	this.target = req.Target
+	this.lock.Unlock()
+	if !utils.TopicMatch(this.network.Topic, req.Type) {
+		log.Warn("invalid topic", "request_topic", req.Type)
 		return
 	}
 
@@ -62,29 +118,95 @@ func (this *connection) loop() {
 		Message: "",
 	}
 
+	if req.Compress != "" && !this.network.AcceptCompress(req.Compress) {
+		sessionsFailed.Inc(this.network.Topic, reasonCompress)
+		initMsg.Message = fmt.Sprintf("compress method [%s] not accepted", req.Compress)
+		initMsg.Reason = reasonCompress
+		this.setReason(reasonCompress, initMsg.Message)
+		log.Warn("compress method not accepted", "compress", req.Compress)
+		msg.WriteMsgWith(this.cli, this.codec, initMsg)
+		return
+	}
+
+	// 带目标地址的请求（exit模式）检查之后连接目标，否则连接配置的后端
+	backend := net.JoinHostPort(this.network.BackendHost, fmt.Sprintf("%d", this.network.BackendPort))
+	if req.Target != "" {
+		addr, err := this.network.ExitAddr(req.Target)
+		if err != nil {
+			sessionsFailed.Inc(this.network.Topic, reasonTarget)
+			this.setReason(reasonTarget, err.Error())
+			log.Warn("target refused", "target", req.Target, "error", err)
+			initMsg.Message = err.Error()
+			initMsg.Reason = reasonTarget
+			msg.WriteMsgWith(this.cli, this.codec, initMsg)
+			return
+		}
+		backend = addr
+		log = log.With("target", req.Target)
+	} else if this.network.BackendHost == "" {
+		sessionsFailed.Inc(this.network.Topic, reasonTarget)
+		initMsg.Message = "target required"
+		initMsg.Reason = reasonTarget
+		this.setReason(reasonTarget, initMsg.Message)
+		log.Warn("target required, no backend configured")
+		msg.WriteMsgWith(this.cli, this.codec, initMsg)
+		return
+	}
+
 	// 收到请求之后，先连接服务器，确定之后再说
-	svrConn, err := net.Dial(
-		"tcp",
-		fmt.Sprintf("%s:%d", this.network.BackendHost, this.network.BackendPort),
-	)
+	start := time.Now()
+	svrConn, err := net.DialTimeout("tcp", backend, time.Duration(utils.HandshakeTimeout)*time.Millisecond)
 	if err != nil {
-		fmt.Println("Error connecting:", err)
+		sessionsFailed.Inc(this.network.Topic, reasonBackend)
+		this.setReason(reasonBackend, err.Error())
+		log.Warn("connect to backend failed", "error", err, "backend_dial", time.Since(start))
 		initMsg.Message = err.Error()
-		msg.WriteMsg(this.cli, initMsg)
+		initMsg.Reason = reasonBackend
+		msg.WriteMsgWith(this.cli, this.codec, initMsg)
 		return
 	}
 	defer svrConn.Close()
-	tcpConn, _ := svrConn.(*net.TCPConn)
-	this.svr = tcpConn
+	this.lock.Lock()
+	this.svr = svrConn
+	this.lock.Unlock()
+	backendDial := time.Since(start)
 
 	// 回复
-	msg.WriteMsg(this.cli, initMsg)
+	msg.WriteMsgWith(this.cli, this.codec, initMsg)
+
+	// 和in之间压缩数据
+	dataConn, err := utils.Compress(this.cli, req.Compress)
+	if err != nil {
+		log.Warn("compress failed", "error", err)
+		this.setReason(reasonCompress, err.Error())
+		return
+	}
+	log.Info("start data exchange", "backend", svrConn.RemoteAddr().String(), "compress", req.Compress,
+		"backend_dial", backendDial)
+	sessionsStarted.Inc(this.network.Topic)
 
 	// 开始数据交换
-	utils.Join(this.cli, this.svr, this)
+	var fromBytes, toBytes int64
+	first, err := utils.JoinCounted(dataConn, this.svr, this, &fromBytes, &toBytes)
+	switch {
+	case first == dataConn && err == nil:
+		this.setReason(reasonClientEOF, "")
+	case first == dataConn:
+		this.setReason(reasonClientError, err.Error())
+	case err == nil:
+		this.setReason(reasonUpstreamEOF, "")
+	default:
+		this.setReason(reasonUpstreamError, err.Error())
+	}
+	this.lock.Lock()
+	this.bytesIn, this.bytesOut = toBytes, fromBytes
+	this.lock.Unlock()
+	sessionBytes.Add(float64(toBytes), this.network.Topic, "in")
+	sessionBytes.Add(float64(fromBytes), this.network.Topic, "out")
+	log.Info("data exchange finished", "bytes_in", toBytes, "bytes_out", fromBytes)
 }
 
-func (this connection) Shutdown() {
+func (this *connection) Shutdown() {
 	this.shutdown.Begin()
 }
 
@@ -97,18 +219,17 @@ func (this *connection) Run() {
 
 	// 等待关闭
 	this.shutdown.WaitBegin()
-	fmt.Printf("start to shutdown connection %p\n", this)
+	this.log.Debug("start to shutdown connection")
 
 	// 关闭socket
-	this.cli.CloseRead()
-	this.cli.CloseWrite()
+	utils.CloseReadWrite(this.cli)
 	if this.svr != nil {
-		this.svr.CloseRead()
-		this.svr.CloseWrite()
+		utils.CloseReadWrite(this.svr)
 	}
 
 	// 等待loop结束
 	this.loopShutdown.WaitComplete()
+	this.access()
 	// 成功结束
 	this.shutdown.Complete()
 }
@@ -118,27 +239,32 @@ func (this *connection) Run() {
 type connectionMng struct {
 	sessions map[*connection]int
 	sync.Mutex
+	access *logs.AccessLog // 访问日志，没有配置时为nil
 }
 
-func NewControl() *connectionMng {
+func NewControl(access *logs.AccessLog) *connectionMng {
 	return &connectionMng{
+		access:   access,
 		sessions: make(map[*connection]int),
 	}
 }
 
-func (this *connectionMng) NewConnection(cli *net.TCPConn, network *Network) {
+func (this *connectionMng) NewConnection(cli net.Conn, network *Network, codec msg.Codec) {
 	s := &connection{
 		c:            this,
 		cli:          cli,
+		codec:        codec,
 		shutdown:     utils.NewShutdown(true),
 		loopShutdown: utils.NewShutdown(false),
 		network:      network,
+		id:           utils.RandId(8),
 	}
+	s.log = logs.With("conn", s.id, "topic", network.Topic)
 	go s.Run()
 }
 
 func (this *connectionMng) Add(s *connection) {
-	fmt.Printf("add connection %p\n", s)
+	s.log.Debug("add connection")
 	this.Lock()
 	defer this.Unlock()
 
@@ -150,7 +276,7 @@ func (this *connectionMng) Add(s *connection) {
 }
 
 func (this *connectionMng) Del(s *connection) {
-	fmt.Printf("del connection %p\n", s)
+	s.log.Debug("del connection")
 	this.Lock()
 	defer this.Unlock()
 
@@ -161,18 +287,18 @@ func (this *connectionMng) Del(s *connection) {
 	}
 }
 
-func (this connectionMng) Shutdown() {
-	fmt.Printf("start to shutdown connectionMng\n")
+func (this *connectionMng) Shutdown() {
+	logs.Debug("start to shutdown connectionMng")
 	this.Lock()
 
 	// 尝试关闭
 	for k, _ := range this.sessions {
-		k.Shutdown()
+		k.Terminate(reasonShutdown, "")
 	}
 	this.Unlock()
 }
 
-func (this connectionMng) WaitComplele() {
+func (this *connectionMng) WaitComplele() {
 	for {
 		time.Sleep(time.Millisecond * 100)
 		this.Lock()
@@ -188,8 +314,9 @@ func (this connectionMng) WaitComplele() {
 
 /////////////////////////////////////////////////////////////////////////////
 type sessionGroup struct {
+	o   *Out
 	c   *connectionMng
-	mng *net.TCPConn
+	mng net.Conn
 
 	shutdown          *utils.Shutdown
 	heartbeatShutdown *utils.Shutdown
@@ -197,71 +324,155 @@ type sessionGroup struct {
 	lastPing          time.Time
 
 	abortShutdown *utils.Shutdown
+	stateLock     sync.Mutex // 保护shutdown和breakFlag，停止可能发生在任意时刻（例如重新加载配置）
 	breakFlag     bool
+
+	lease string              // proxy分配的租约，重连时带上
+	caps  *utils.Capabilities // 和proxy协商的版本和特性
+
+	codecLock sync.Mutex
+	codec     msg.Codec // 控制连接协商的编码
+
+	muxLock sync.Mutex
+	mux     *mux.Session // 数据连接共享的多路复用连接
+	refused int          // 连续被proxy拒绝注册的次数
+
+	log *logs.Logger // 附带topic、server字段
 }
 
-func NewSessionGroup() *sessionGroup {
+func NewSessionGroup(o *Out) *sessionGroup {
 	return &sessionGroup{
+		o:             o,
+		shutdown:      utils.NewShutdown(true),
 		abortShutdown: utils.NewShutdown(true),
 		breakFlag:     false,
 	}
 }
 
-func (this sessionGroup) Shutdown() {
+func (this *sessionGroup) Shutdown() {
 	this.abortShutdown.Begin()
 }
 
-func (this sessionGroup) ShutdownAndRetry() {
-	this.shutdown.Begin()
+func (this *sessionGroup) ShutdownAndRetry() {
+	this.stateLock.Lock()
+	shutdown := this.shutdown
+	this.stateLock.Unlock()
+	shutdown.Begin()
+}
+
+func (this *sessionGroup) stopped() bool {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	return this.breakFlag
 }
 
 func (this *sessionGroup) Run(network *Network) {
 	defer this.abortShutdown.Complete()
+	this.log = logs.With("topic", network.Topic,
+		"server", net.JoinHostPort(network.ServerHost, fmt.Sprintf("%d", network.ServerPort)))
 
 	go func() {
 		this.abortShutdown.WaitBegin()
+		this.stateLock.Lock()
 		this.breakFlag = true
+		this.stateLock.Unlock()
 		this.ShutdownAndRetry()
 	}()
 
-	for !this.breakFlag {
-		conn, err := net.Dial(
-			"tcp",
-			fmt.Sprintf("%s:%d", network.ServerHost, network.ServerPort),
-		)
+	for !this.stopped() {
+		conn, err := utils.Dial(network.ServerHost, network.ServerPort, network.TLS)
 		if err != nil {
-			fmt.Println("Error connecting:", err)
-			time.Sleep(time.Millisecond * time.Duration(gConfig.RetryInterval))
+			this.log.Warn("connect to proxy failed", "error", err)
+			time.Sleep(time.Millisecond * time.Duration(this.o.Config().RetryInterval))
 			continue
 		}
 
 		this.lastPing = time.Now()
 		this.beatCh = make(chan int)
 		this.heartbeatShutdown = utils.NewShutdown(false)
+		this.stateLock.Lock()
+		if this.breakFlag {
+			this.stateLock.Unlock()
+			conn.Close()
+			break
+		}
 		this.shutdown = utils.NewShutdown(true)
-		this.c = NewControl()
-		tcpConn, _ := conn.(*net.TCPConn)
-		this.mng = tcpConn
+		this.stateLock.Unlock()
+		this.c = NewControl(this.o.access)
+		this.mng = conn
+		this.setCodec(msg.JSON)
 
 		go this.loop(network)
-		go this.heartbeat()
+		go this.heartbeat(network)
 		// 监控退出
 		this.manager()
+		registered.Set(0, network.Topic)
 
-		if this.breakFlag {
+		if this.stopped() {
 			break
 		}
-		time.Sleep(time.Millisecond * time.Duration(gConfig.RetryInterval))
-		fmt.Printf("retry connect in %p\n", this)
+		time.Sleep(this.retryInterval())
+		this.log.Info("retry connect")
+		reconnects.Inc(network.Topic)
+	}
+}
+
+// 新建数据连接，开启多路复用时在共享的连接上新建stream
+func (this *sessionGroup) dialData(network *Network) (net.Conn, error) {
+	if !network.Mux {
+		return utils.Dial(network.ServerHost, network.ServerPort, network.TLS)
+	}
+
+	this.muxLock.Lock()
+	defer this.muxLock.Unlock()
+
+	if this.mux == nil || this.mux.IsClosed() {
+		session, err := mux.Dial(network.ServerHost, network.ServerPort, network.TLS)
+		if err != nil {
+			return nil, err
+		}
+		this.log.Info("new mux session")
+		this.mux = session
+	}
+	return this.mux.Open()
+}
+
+func (this *sessionGroup) closeMux() {
+	this.muxLock.Lock()
+	defer this.muxLock.Unlock()
+
+	if this.mux != nil {
+		this.mux.Close()
+		this.mux = nil
+	}
+}
+
+func (this *sessionGroup) setCodec(codec msg.Codec) {
+	this.codecLock.Lock()
+	defer this.codecLock.Unlock()
+	this.codec = codec
+}
+
+func (this *sessionGroup) getCodec() msg.Codec {
+	this.codecLock.Lock()
+	defer this.codecLock.Unlock()
+	return this.codec
+}
+
+// 重连间隔，注册被拒绝时逐次翻倍
+func (this *sessionGroup) retryInterval() time.Duration {
+	n := this.refused
+	if n > utils.MaxRetryBackoff {
+		n = utils.MaxRetryBackoff
 	}
+	return time.Millisecond * time.Duration(this.o.Config().RetryInterval<<uint(n))
 }
 
 func (this *sessionGroup) manager() {
 	this.shutdown.WaitBegin()
-	fmt.Printf("start to shutdown sessionGroup %p\n", this)
+	this.log.Debug("start to shutdown sessionGroup")
 
-	this.mng.CloseRead()
-	this.mng.CloseWrite()
+	utils.CloseReadWrite(this.mng)
 
 	// 关闭数据连接
 	this.c.Shutdown()
@@ -273,6 +484,7 @@ func (this *sessionGroup) manager() {
 	this.heartbeatShutdown.WaitComplete()
 
 	this.mng.Close()
+	this.closeMux()
 	// 回收
 	this.shutdown.Complete()
 }
@@ -283,30 +495,64 @@ func (this *sessionGroup) loop(network *Network) {
 	// 请求注册
 	uniqKey := utils.Magic()
 	initMsg := &msg.OutRequest{
-		Magic:   uniqKey,
-		Version: utils.Version,
-		Type:    network.Topic,
+		Magic:      uniqKey,
+		Version:    utils.Version,
+		MinVersion: utils.MinVersion,
+		Features:   utils.Features,
+		Type:       network.Topic,
+		Lease:      this.lease,
+		Weight:     network.Weight,
+		Standby:    network.Standby,
+		Codecs:     network.Codecs,
+		Compress:   network.CompressMethods(),
+	}
+	if len(initMsg.Codecs) == 0 {
+		initMsg.Codecs = msg.CodecNames()
+	}
+	if network.Token != "" {
+		initMsg.Timestamp = time.Now().Unix()
+		initMsg.Sign = utils.Sign(network.Token, uniqKey, initMsg.Type, initMsg.Timestamp)
 	}
 	msg.WriteMsg(this.mng, initMsg)
 
 	// 确认回包
-	if err := msg.CheckResponse(this.mng, uniqKey, "OutRequest"); err != nil {
-		fmt.Println(err.Error())
+	resp, err := msg.ReadResponse(this.mng, uniqKey, "OutRequest")
+	if err != nil {
+		if resp != nil {
+			// proxy拒绝注册，退避之后再试
+			this.refused++
+			this.log.Warn("register topic refused",
+				"error", resp.Message, "retry", this.retryInterval().String())
+		} else {
+			this.log.Warn("register topic failed", "error", err)
+		}
 		return
 	}
+	this.refused = 0
+	this.caps = msg.ResponseCapabilities(resp)
+	this.lease = ""
+	if this.caps.Has(utils.FeatureLease) {
+		this.lease = resp.Lease
+	} else if network.Standby || network.Weight > 0 {
+		this.log.Warn("proxy does not support lease, standby and weight are ignored", "version", this.caps.Version)
+	}
+	this.setCodec(msg.NegotiateCodec([]string{resp.Codec}))
+	this.log.Info("register topic ok",
+		"version", this.caps.Version, "features", this.caps.Features, "codec", resp.Codec)
+	registered.Set(1, network.Topic)
 
 	// 等待数据连接请求和心跳
 	for {
 		// 等待消息
 		d, tp, err := msg.ReadMsg(this.mng)
 		if err != nil {
-			fmt.Printf("read message error [%s]\n", err.Error())
+			this.log.Warn("read control message failed", "error", err)
 			break
 		}
 		if tp == "Pong" {
 			_, ok := d.(*msg.Pong)
 			if !ok {
-				fmt.Printf("invalid out resp type\n")
+				this.log.Warn("invalid Pong message")
 				break
 			}
 			this.beatCh <- 1
@@ -315,51 +561,59 @@ func (this *sessionGroup) loop(network *Network) {
 
 		resp, ok := d.(*msg.NewDataRequest)
 		if !ok {
-			fmt.Printf("invalid out resp type\n")
+			this.log.Warn("invalid control message", "request", tp)
 			break
 		}
 
 		if resp.Type != network.Topic {
-			fmt.Printf("invalid Topic %s\n", resp.Type)
+			this.log.Warn("invalid topic", "request_topic", resp.Type)
 			continue
 		}
-		fmt.Printf("recv NewDataRequest\n")
+		this.log.Debug("recv NewDataRequest")
 
 		go func() {
-			newConn, err := net.Dial(
-				"tcp",
-				fmt.Sprintf("%s:%d", network.ServerHost, network.ServerPort),
-			)
+			newConn, err := this.dialData(network)
 			if err != nil {
-				fmt.Println("Error connecting:", err)
+				sessionsFailed.Inc(network.Topic, reasonDial)
+				this.log.Warn("connect data conn failed", "error", err)
 				return
 			}
 
 			uniqKey := utils.Magic()
 			initMsg := &msg.OutDataRequest{
-				Magic:   uniqKey,
-				Type:    network.Topic,
-				Version: utils.Version,
+				Magic:      uniqKey,
+				Type:       network.Topic,
+				Version:    utils.Version,
+				MinVersion: utils.MinVersion,
+				Features:   utils.Features,
+				Lease:      this.lease,
+			}
+			if network.Token != "" {
+				initMsg.Timestamp = time.Now().Unix()
+				initMsg.Sign = utils.Sign(network.Token, uniqKey, initMsg.Type, initMsg.Timestamp)
 			}
 			msg.WriteMsg(newConn, initMsg)
 
-			tcpConn, _ := newConn.(*net.TCPConn)
-			if err := msg.CheckResponse(tcpConn, uniqKey, "OutDataRequest"); err != nil {
-				fmt.Println(err.Error())
-				tcpConn.Close()
+			newConn.SetReadDeadline(time.Now().Add(time.Duration(utils.HandshakeTimeout) * time.Millisecond))
+			if err := msg.CheckResponse(newConn, uniqKey, "OutDataRequest"); err != nil {
+				sessionsFailed.Inc(network.Topic, reasonHandshake)
+				this.log.Warn("register data conn failed", "magic", uniqKey, "error", err)
+				newConn.Close()
 				return
 			}
+			newConn.SetReadDeadline(time.Time{})
 
-			this.c.NewConnection(tcpConn, network)
+			this.c.NewConnection(newConn, network, this.getCodec())
 		}()
 	}
 }
 
-func (this *sessionGroup) heartbeat() {
+func (this *sessionGroup) heartbeat(network *Network) {
 	defer this.ShutdownAndRetry()
 	defer this.heartbeatShutdown.Complete()
 
 	flag := false
+	var pingSent time.Time // 最近一次发送Ping的时间，用于统计RTT
 	for {
 		if flag {
 			break
@@ -370,76 +624,25 @@ func (this *sessionGroup) heartbeat() {
 			// 收到心跳回报
 			if !ok {
 				flag = true
+			} else if !pingSent.IsZero() {
+				heartbeatRTT.Observe(time.Since(pingSent).Seconds(), network.Topic)
+				pingSent = time.Time{}
 			}
 			this.lastPing = time.Now()
 			break
-		case <-time.After(time.Millisecond * time.Duration(gConfig.HeartbeatInterval)):
+		case <-time.After(time.Millisecond * time.Duration(this.o.Config().HeartbeatInterval)):
 			// 检查心跳
-			if time.Since(this.lastPing) > time.Millisecond*time.Duration(gConfig.HeartbeatTimeout) {
-				fmt.Println("Lost heartbeat")
+			if time.Since(this.lastPing) > time.Millisecond*time.Duration(this.o.Config().HeartbeatTimeout) {
+				this.log.Warn("lost heartbeat")
+				heartbeatTimeouts.Inc(network.Topic)
 				flag = true
 			}
 
 			// 发送心跳
-			msg.WriteMsg(this.mng, &msg.Ping{})
+			pingSent = time.Now()
+			msg.WriteMsgWith(this.mng, this.getCodec(), &msg.Ping{})
 			break
 		}
 
 	}
 }
-
-func checkConfig() {
-	if jsonBytes, err := json.MarshalIndent(gConfig, "", "    "); err != nil {
-		panic(err)
-	} else {
-		fmt.Println(string(jsonBytes))
-	}
-	if len(gConfig.Networks) < 1 || len(gConfig.Networks) > 16 {
-		panic("invalid network config,network count in(1,16")
-	}
-
-	// 不同host/port 的subject可以相同
-	//	topics := make(map[string]int)
-	//	for _, v := range gConfig.Networks {
-	//		topics[v.Topic] = 0
-	//	}
-	//	if len(topics) != len(gConfig.Networks) {
-	//		panic("topic can not duplicate")
-	//	}
-}
-
-func main() {
-	confPath := flag.String("config", "", "配置文件")
-	flag.Parse()
-	if confPath == nil || *confPath == "" {
-		gConfig = defaultConfig()
-	} else {
-		gConfig = parseConfig(*confPath)
-	}
-	checkConfig()
-
-	fmt.Println("Starting the server ...")
-	utils.RandomSeed()
-
-	// 信号 和谐的退出
-	sessions := make([]*sessionGroup, 0)
-	exitMng := utils.NewExitManager()
-	go exitMng.Run(func() {
-		for _, v := range sessions {
-			v.Shutdown()
-		}
-	})
-
-	var wait sync.WaitGroup
-	wait.Add(len(gConfig.Networks))
-	for _, v := range gConfig.Networks {
-		s := NewSessionGroup()
-		network := v
-		sessions = append(sessions, s)
-		go func() {
-			defer wait.Done()
-			s.Run(network)
-		}()
-	}
-	wait.Wait()
-}
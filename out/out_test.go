@@ -0,0 +1,80 @@
+package out
+
+import (
+	"net"
+	"testing"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/utils"
+)
+
+// 用net.Pipe模拟proxy的数据连接，执行一次loop
+func runConnection(t *testing.T, network *Network, req *msg.DataActiveRequest) (*connection, *msg.Response) {
+	t.Helper()
+	a, b := net.Pipe()
+	defer a.Close()
+	c := &connection{
+		c:            NewControl(nil),
+		cli:          b,
+		codec:        msg.JSON,
+		shutdown:     utils.NewShutdown(true),
+		loopShutdown: utils.NewShutdown(false),
+		network:      network,
+		id:           "test",
+		log:          logs.With("conn", "test"),
+	}
+	go c.loop()
+
+	if err := msg.WriteMsg(a, req); err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := msg.ReadMsg(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.loopShutdown.WaitComplete()
+	return c, m.(*msg.Response)
+}
+
+// 拒绝的原因和错误同时出现在回包和访问日志中
+func TestConnectionRefused(t *testing.T) {
+	cases := []struct {
+		name    string
+		network *Network
+		req     *msg.DataActiveRequest
+		reason  string
+	}{
+		{
+			"compress",
+			&Network{Topic: "db", BackendHost: "127.0.0.1", BackendPort: 1, Compress: []string{}},
+			&msg.DataActiveRequest{Magic: "m", Type: "db", Compress: utils.CompressDeflate},
+			reasonCompress,
+		},
+		{
+			"target required",
+			&Network{Topic: "db"},
+			&msg.DataActiveRequest{Magic: "m", Type: "db"},
+			reasonTarget,
+		},
+		{
+			"target not allowed",
+			&Network{Topic: "db", Exit: []*ExitRule{{Hosts: []string{"10.0.0.0/8"}}}},
+			&msg.DataActiveRequest{Magic: "m", Type: "db", Target: "192.168.1.1:22"},
+			reasonTarget,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, resp := runConnection(t, c.network, c.req)
+			if resp.Reason != c.reason || resp.Message == "" {
+				t.Fatalf("response reason %q message %q, expect reason %s", resp.Reason, resp.Message, c.reason)
+			}
+			conn.lock.Lock()
+			defer conn.lock.Unlock()
+			if conn.reason != c.reason || conn.err != resp.Message {
+				t.Fatalf("access reason %q error %q, expect %s %q", conn.reason, conn.err, c.reason, resp.Message)
+			}
+		})
+	}
+}
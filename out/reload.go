@@ -0,0 +1,29 @@
+package out
+
+import (
+	"reflect"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// 替换配置（SIGHUP）
+//
+// 增删network，重试间隔和心跳参数立即生效；监控指标和访问日志需要重启
+func (this *Out) Reload(conf *Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	this.reloadLock.Lock()
+	defer this.reloadLock.Unlock()
+
+	old := this.Config()
+	if conf.Metrics != old.Metrics || !reflect.DeepEqual(conf.Access, old.Access) {
+		logs.Warn("changes of metrics/access_log require restart, ignored")
+		conf.Metrics, conf.Access = old.Metrics, old.Access
+	}
+
+	this.conf.Store(conf)
+	this.g.Apply(conf.Networks)
+	logs.Info("config reloaded", "networks", len(conf.Networks))
+	return nil
+}
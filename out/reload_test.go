@@ -0,0 +1,83 @@
+package out
+
+import (
+	"testing"
+)
+
+func testNetwork(topic string) *Network {
+	return &Network{
+		ServerHost:  "127.0.0.1",
+		ServerPort:  1,
+		BackendHost: "127.0.0.1",
+		BackendPort: 1,
+		Topic:       topic,
+	}
+}
+
+// 当前的sessionGroup，按topic索引
+func groupsByTopic(o *Out) map[string]*sessionGroup {
+	o.g.Lock()
+	defer o.g.Unlock()
+	groups := make(map[string]*sessionGroup)
+	for s, v := range o.g.groups {
+		groups[v.Topic] = s
+	}
+	return groups
+}
+
+func TestReloadNetworks(t *testing.T) {
+	conf := DefaultConfig()
+	conf.RetryInterval = 100
+	conf.Networks = []*Network{testNetwork("a"), testNetwork("b"), testNetwork("c")}
+	o, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Start()
+	defer func() {
+		o.Shutdown()
+		o.Wait()
+	}()
+	before := groupsByTopic(o)
+
+	// a不变，b修改权重，删除c，新增d
+	conf2 := DefaultConfig()
+	conf2.RetryInterval = 200
+	conf2.Metrics = "127.0.0.1:40013"
+	b := testNetwork("b")
+	b.Weight = 3
+	conf2.Networks = []*Network{testNetwork("a"), b, testNetwork("d")}
+	if err := o.Reload(conf2); err != nil {
+		t.Fatal(err)
+	}
+	after := groupsByTopic(o)
+
+	if len(after) != 3 {
+		t.Fatalf("got %d groups, expect 3", len(after))
+	}
+	if after["a"] != before["a"] {
+		t.Error("unchanged network should keep its group")
+	}
+	if after["b"] == nil || after["b"] == before["b"] {
+		t.Error("modified network should be restarted")
+	}
+	if after["c"] != nil {
+		t.Error("removed network still running")
+	}
+	if after["d"] == nil {
+		t.Error("new network not started")
+	}
+	if o.Config().RetryInterval != 200 || o.Config().Metrics != "" {
+		t.Errorf("retry_interval should apply and metrics require restart: %+v", o.Config())
+	}
+
+	// 非法的配置不替换
+	conf3 := DefaultConfig()
+	conf3.Networks = []*Network{{Topic: "x"}}
+	if err := o.Reload(conf3); err == nil {
+		t.Fatal("invalid config should be refused")
+	}
+	if len(groupsByTopic(o)) != 3 {
+		t.Fatal("invalid config changed the groups")
+	}
+}
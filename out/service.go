@@ -0,0 +1,53 @@
+package out
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// out服务，可以嵌入到其他程序中：New之后Start，Shutdown之后Wait等待所有连接结束
+type Out struct {
+	conf       atomic.Pointer[Config] // 重新加载配置时整体替换
+	reloadLock sync.Mutex
+	access     *logs.AccessLog // 访问日志，没有配置时为nil
+	g          *groupMng
+}
+
+// 校验配置并打开访问日志
+func New(conf *Config) (*Out, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	access, err := logs.OpenAccessLog(conf.Access, "out")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Out{access: access}
+	s.g = NewGroupMng(s)
+	s.conf.Store(conf)
+	return s, nil
+}
+
+// 当前的配置，不要修改
+func (this *Out) Config() *Config {
+	return this.conf.Load()
+}
+
+// 连接proxy并注册所有network，掉线后自动重连
+func (this *Out) Start() {
+	this.g.Apply(this.Config().Networks)
+}
+
+// 注销所有network并断开连接
+func (this *Out) Shutdown() {
+	this.g.Shutdown()
+}
+
+// 等待所有的连接结束
+func (this *Out) Wait() {
+	this.g.Wait()
+	this.access.Close()
+}
@@ -0,0 +1,40 @@
+package out
+
+import (
+	"github.com/qjw/proxy/metrics"
+)
+
+// 监控指标，配置metrics之后以Prometheus文本格式暴露
+var (
+	sessionsStarted = metrics.NewCounter("out_sessions_started_total",
+		"Sessions connected to the backend and forwarding.", "topic")
+	sessionsFailed = metrics.NewCounter("out_sessions_failed_total",
+		"Sessions failed before data exchange.", "topic", "reason")
+	sessionBytes = metrics.NewCounter("out_bytes_total",
+		"Bytes exchanged by finished sessions, direction in (proxy->backend) or out (backend->proxy).", "topic", "direction")
+	reconnects = metrics.NewCounter("out_reconnects_total",
+		"Control connection reconnects to proxy.", "topic")
+	heartbeatTimeouts = metrics.NewCounter("out_heartbeat_timeouts_total",
+		"Control connections dropped because of lost heartbeat.", "topic")
+	heartbeatRTT = metrics.NewHistogram("out_heartbeat_rtt_seconds",
+		"Round trip time of Ping/Pong on the control connection.",
+		[]float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}, "topic")
+	registered = metrics.NewGauge("out_registered",
+		"Whether the topic is currently registered on proxy (1) or not (0).", "topic")
+)
+
+// 失败的原因，作为reason标签，也用作访问日志中连接结束的原因
+const (
+	reasonDial      = "dial"
+	reasonHandshake = "handshake"
+	reasonBackend   = "backend_dial"
+	reasonCompress  = "compress"
+	reasonTarget    = "target"
+
+	// 数据交换开始之后
+	reasonClientEOF     = "client_eof"
+	reasonClientError   = "client_error"
+	reasonUpstreamEOF   = "upstream_eof"
+	reasonUpstreamError = "upstream_error"
+	reasonShutdown      = "shutdown"
+)
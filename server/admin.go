@@ -0,0 +1,227 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/qjw/proxy/logs"
+)
+
+// 管理接口，返回JSON
+//
+//	GET  /topics               已注册的topic和out
+//	GET  /sessions             正在转发的连接
+//	POST /topics/kick?name=    踢掉topic下所有的out，或者?id=踢掉指定的out
+//	POST /sessions/kick?id=    断开指定的连接
+//	POST /reload               重新加载配置文件
+type tunnelInfo struct {
+	Id         string    `json:"id"`
+	Topic      string    `json:"topic"`
+	Remote     string    `json:"remote"`             // out的地址
+	Identity   string    `json:"identity,omitempty"` // out的证书身份
+	Version    string    `json:"version"`
+	Registered time.Time `json:"registered"`
+	Standby    bool      `json:"standby"` // 是否备用（尚未提升）
+	Weight     int       `json:"weight"`
+	Free       int       `json:"free"`    // 空闲的数据连接数
+	Proxies    int       `json:"proxies"` // 正在转发的连接数
+}
+
+type proxyInfo struct {
+	Id       string    `json:"id"` // 会话ID，和in、out日志中的session一致
+	Topic    string    `json:"topic"`
+	Client   string    `json:"client"`           // in的地址
+	Tunnel   string    `json:"tunnel,omitempty"` // 服务的out（tunnel id）
+	Target   string    `json:"target,omitempty"` // SOCKS5/HTTP CONNECT请求的目标地址
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytes_in"`  // in发往out的字节数
+	BytesOut int64     `json:"bytes_out"` // out发往in的字节数
+}
+
+func newTunnelInfo(t *tunnel, standby bool) *tunnelInfo {
+	return &tunnelInfo{
+		Id:         t.id,
+		Topic:      t.Type(),
+		Remote:     t.mng.RemoteAddr().String(),
+		Identity:   t.identity,
+		Version:    t.caps.Version,
+		Registered: t.created,
+		Standby:    standby,
+		Weight:     t.Weight(),
+		Free:       len(t.frees),
+		Proxies:    t.ProxyCount(),
+	}
+}
+
+func (this *ControlRegistry) Snapshot() []*tunnelInfo {
+	this.RLock()
+	defer this.RUnlock()
+
+	infos := make([]*tunnelInfo, 0)
+	for _, set := range this.tunnels {
+		for _, t := range set.tunnels {
+			infos = append(infos, newTunnelInfo(t, false))
+		}
+		for _, t := range set.standbys {
+			infos = append(infos, newTunnelInfo(t, true))
+		}
+	}
+	return infos
+}
+
+// 踢掉topic下所有的tunnel（name）或者指定的tunnel（id），返回踢掉的个数
+func (this *ControlRegistry) Kick(name, id string) int {
+	this.RLock()
+	defer this.RUnlock()
+
+	count := 0
+	for tp, set := range this.tunnels {
+		for _, list := range [][]*tunnel{set.tunnels, set.standbys} {
+			for _, t := range list {
+				if (name != "" && tp == name) || (id != "" && t.id == id) {
+					t.Shutdown()
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+func (this *ProxyRegistry) Snapshot() []*proxyInfo {
+	this.Lock()
+	defer this.Unlock()
+
+	infos := make([]*proxyInfo, 0, len(this.proxies))
+	for p := range this.proxies {
+		info := &proxyInfo{
+			Id:       p.id,
+			Topic:    p.m.Type,
+			Client:   p.cli.RemoteAddr().String(),
+			Target:   p.m.Target,
+			Started:  p.created,
+			BytesIn:  atomic.LoadInt64(&p.bytesIn),
+			BytesOut: atomic.LoadInt64(&p.bytesOut),
+		}
+		if t := p.Tunnel(); t != nil {
+			info.Tunnel = t.id
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (this *ProxyRegistry) Kick(id string) int {
+	this.Lock()
+	defer this.Unlock()
+
+	count := 0
+	for p := range this.proxies {
+		if p.id == id {
+			p.Terminate(reasonKicked, "")
+			count++
+		}
+	}
+	return count
+}
+
+func writeJson(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJson(w, code, map[string]string{"message": message})
+}
+
+// 管理接口，reload为空时不提供/reload
+func (this *Server) AdminHandler(reload func() error) http.Handler {
+	p, c := this.p, this.c
+	router := http.NewServeMux()
+	router.HandleFunc("/topics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJson(w, http.StatusOK, c.Snapshot())
+	})
+	router.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJson(w, http.StatusOK, p.Snapshot())
+	})
+	router.HandleFunc("/topics/kick", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		name := r.URL.Query().Get("name")
+		id := r.URL.Query().Get("id")
+		if name == "" && id == "" {
+			writeError(w, http.StatusBadRequest, "name or id required")
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]int{"kicked": c.Kick(name, id)})
+	})
+	router.HandleFunc("/sessions/kick", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeError(w, http.StatusBadRequest, "id required")
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]int{"kicked": p.Kick(id)})
+	})
+	router.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if reload == nil {
+			writeError(w, http.StatusNotImplemented, "reload not supported")
+			return
+		}
+		if err := reload(); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]string{"message": "ok"})
+	})
+	return this.adminAuth(router)
+}
+
+// 管理接口的认证头
+const AdminTokenHeader = "X-Admin-Token"
+
+// 配置了admin_token时所有请求都要带上相同的X-Admin-Token；没有配置时修改状态的POST请求也要带上该头（值任意），
+// 浏览器跨域发送自定义头之前必须预检，网页就不能通过no-cors的POST触发管理操作
+func (this *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := this.Config().AdminToken
+		got := r.Header.Get(AdminTokenHeader)
+		switch {
+		case token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(got)) != 1:
+			writeError(w, http.StatusUnauthorized, "invalid "+AdminTokenHeader)
+		case token == "" && r.Method != http.MethodGet && got == "":
+			writeError(w, http.StatusForbidden, AdminTokenHeader+" header required")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (this *Server) serveAdmin(addr string, reload func() error) {
+	logs.Info("admin listening", "addr", addr)
+	if err := http.ListenAndServe(addr, this.AdminHandler(reload)); err != nil {
+		logs.Error("admin listen failed", "error", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func adminRequest(h http.Handler, method, path, token string) int {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set(AdminTokenHeader, token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAdminAuth(t *testing.T) {
+	conf := DefaultConfig()
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := 0
+	h := s.AdminHandler(func() error {
+		reloaded++
+		return nil
+	})
+
+	// 没有配置admin_token：GET不认证，POST需要自定义头
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{http.MethodGet, "/topics", "", http.StatusOK},
+		{http.MethodPost, "/reload", "", http.StatusForbidden},
+		{http.MethodPost, "/topics/kick?name=db", "", http.StatusForbidden},
+		{http.MethodPost, "/sessions/kick?id=x", "", http.StatusForbidden},
+		{http.MethodPost, "/reload", "any", http.StatusOK},
+	}
+	for _, c := range cases {
+		if code := adminRequest(h, c.method, c.path, c.token); code != c.code {
+			t.Errorf("%s %s: got %d, expect %d", c.method, c.path, code, c.code)
+		}
+	}
+	if reloaded != 1 {
+		t.Fatalf("reload called %d times, expect 1", reloaded)
+	}
+
+	// 配置之后所有请求都要带上相同的token
+	conf2 := *conf
+	conf2.AdminToken = "secret"
+	s.conf.Store(&conf2)
+	cases = []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{http.MethodGet, "/topics", "", http.StatusUnauthorized},
+		{http.MethodGet, "/sessions", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/sessions", "secret", http.StatusOK},
+		{http.MethodPost, "/reload", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/reload", "secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		if code := adminRequest(h, c.method, c.path, c.token); code != c.code {
+			t.Errorf("%s %s token %q: got %d, expect %d", c.method, c.path, c.token, code, c.code)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+const (
+	BalanceRoundRobin string = "roundrobin" // 轮询（默认）
+	BalanceLeast      string = "least"      // 正在工作的proxy最少
+	BalanceWeighted   string = "weighted"   // 按照out注册时的权重（平滑加权轮询）
+)
+
+// 同一个Topic下注册的所有tunnel，由ControlRegistry的锁保护
+type tunnelSet struct {
+	tunnels  []*tunnel // 正在服务的tunnel
+	standbys []*tunnel // 备用的tunnel，没有可用的tunnel时按注册顺序提升
+	next     int       // 轮询的游标
+}
+
+func (this *tunnelSet) add(t *tunnel) {
+	if t.m.Standby {
+		this.standbys = append(this.standbys, t)
+	} else {
+		this.tunnels = append(this.tunnels, t)
+	}
+}
+
+func removeTunnel(list []*tunnel, t *tunnel) ([]*tunnel, bool) {
+	for i, v := range list {
+		if v == t {
+			return append(list[:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
+
+func (this *tunnelSet) del(t *tunnel) bool {
+	var ok bool
+	if this.tunnels, ok = removeTunnel(this.tunnels, t); ok {
+		return true
+	}
+	this.standbys, ok = removeTunnel(this.standbys, t)
+	return ok
+}
+
+func (this *tunnelSet) empty() bool {
+	return len(this.tunnels) == 0 && len(this.standbys) == 0
+}
+
+// 没有正在服务的tunnel时，提升第一个备用的tunnel
+func (this *tunnelSet) promote() *tunnel {
+	if len(this.tunnels) > 0 || len(this.standbys) == 0 {
+		return nil
+	}
+	t := this.standbys[0]
+	this.standbys = this.standbys[1:]
+	this.tunnels = append(this.tunnels, t)
+	return t
+}
+
+// 按照租约查找，用于out重连时替换自己之前的tunnel
+func (this *tunnelSet) find(lease string) *tunnel {
+	if lease == "" {
+		return nil
+	}
+	for _, v := range this.tunnels {
+		if v.lease == lease {
+			return v
+		}
+	}
+	for _, v := range this.standbys {
+		if v.lease == lease {
+			return v
+		}
+	}
+	return nil
+}
+
+func (this *tunnelSet) pick(balance string) *tunnel {
+	if len(this.tunnels) == 0 {
+		return nil
+	}
+
+	switch balance {
+	case BalanceLeast:
+		return this.least()
+	case BalanceWeighted:
+		return this.weighted()
+	default:
+		return this.roundRobin()
+	}
+}
+
+func (this *tunnelSet) roundRobin() *tunnel {
+	this.next = (this.next + 1) % len(this.tunnels)
+	return this.tunnels[this.next]
+}
+
+func (this *tunnelSet) least() *tunnel {
+	var best *tunnel
+	bestCount := 0
+	for _, v := range this.tunnels {
+		count := v.ProxyCount()
+		if best == nil || count < bestCount {
+			best = v
+			bestCount = count
+		}
+	}
+	return best
+}
+
+// 参考nginx的平滑加权轮询
+func (this *tunnelSet) weighted() *tunnel {
+	var best *tunnel
+	total := 0
+	for _, v := range this.tunnels {
+		w := v.Weight()
+		v.currentWeight += w
+		total += w
+		if best == nil || v.currentWeight > best.currentWeight {
+			best = v
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
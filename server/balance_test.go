@@ -0,0 +1,87 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func testSet(names ...string) *tunnelSet {
+	set := &tunnelSet{}
+	for _, v := range names {
+		t := testTunnel("db", v, v, strings.HasPrefix(v, "standby"))
+		t.lease = v
+		t.proxies = make(map[*proxy]int)
+		set.add(t)
+	}
+	return set
+}
+
+func pickSequence(set *tunnelSet, balance string, n int) string {
+	list := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		list = append(list, set.pick(balance).identity)
+	}
+	return strings.Join(list, ",")
+}
+
+func TestTunnelSetPick(t *testing.T) {
+	if (&tunnelSet{}).pick(BalanceRoundRobin) != nil {
+		t.Fatal("empty set should pick nil")
+	}
+	if testSet("standby").pick(BalanceRoundRobin) != nil {
+		t.Fatal("standby should not be picked")
+	}
+
+	set := testSet("a", "b", "c")
+	if seq := pickSequence(set, BalanceRoundRobin, 6); seq != "b,c,a,b,c,a" {
+		t.Errorf("roundrobin: %s", seq)
+	}
+
+	// 平滑加权轮询，和nginx一致
+	set = testSet("a", "b", "c")
+	set.tunnels[0].m.Weight = 5
+	if seq := pickSequence(set, BalanceWeighted, 7); seq != "a,a,b,a,c,a,a" {
+		t.Errorf("weighted: %s", seq)
+	}
+
+	set = testSet("a", "b", "c")
+	for i, n := range []int{2, 0, 1} {
+		for j := 0; j < n; j++ {
+			set.tunnels[i].proxies[&proxy{}] = 0
+		}
+	}
+	if got := set.pick(BalanceLeast).identity; got != "b" {
+		t.Errorf("least: %s", got)
+	}
+}
+
+func TestTunnelSetPromote(t *testing.T) {
+	set := testSet("a", "standby1", "standby2")
+	if set.promote() != nil {
+		t.Fatal("should not promote while a tunnel is serving")
+	}
+
+	a := set.find("a")
+	if a == nil || set.find("") != nil || set.find("x") != nil {
+		t.Fatal("find by lease failed")
+	}
+	if !set.del(a) || set.del(a) {
+		t.Fatal("del should succeed only once")
+	}
+	if p := set.promote(); p == nil || p.identity != "standby1" {
+		t.Fatalf("promote: %v", p)
+	}
+	if got := set.pick(BalanceRoundRobin).identity; got != "standby1" {
+		t.Fatalf("pick after promote: %s", got)
+	}
+	if set.promote() != nil {
+		t.Fatal("only one standby should be promoted")
+	}
+
+	set.del(set.find("standby1"))
+	set.promote()
+	set.del(set.find("standby2"))
+	if !set.empty() {
+		t.Fatal("set should be empty")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/metrics"
+	"github.com/qjw/proxy/utils"
+)
+
+// 命令行参数，常用字段显式指定时覆盖配置文件和环境变量
+type cliFlags struct {
+	fs        *flag.FlagSet
+	config    *string
+	checkOnly *bool
+	bind      *string
+	port      *uint
+	token     *string
+	admin     *string
+	metrics   *string
+	logLevel  *string
+}
+
+func newFlags(name string) *cliFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &cliFlags{
+		fs:        fs,
+		config:    fs.String("config", "", "配置文件"),
+		checkOnly: fs.Bool("check-config", false, "只校验配置文件，有错误时以非0退出"),
+		bind:      fs.String("bind", "", "绑定的本地主机"),
+		port:      fs.Uint("port", 0, "绑定的本地端口"),
+		token:     fs.String("token", "", "全局认证密钥"),
+		admin:     fs.String("admin", "", "管理接口的监听地址"),
+		metrics:   fs.String("metrics", "", "监控指标的监听地址"),
+		logLevel:  fs.String("log-level", "", "日志级别debug/info/warn/error"),
+	}
+}
+
+func (this *cliFlags) apply(conf *Config) error {
+	var err error
+	this.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bind":
+			conf.Bind = *this.bind
+		case "port":
+			if *this.port == 0 || *this.port > 65535 {
+				err = fmt.Errorf("invalid --port %d", *this.port)
+			}
+			conf.Port = uint16(*this.port)
+		case "token":
+			conf.Token = *this.token
+		case "admin":
+			conf.Admin = *this.admin
+		case "metrics":
+			conf.Metrics = *this.metrics
+		case "log-level":
+			if conf.Log == nil {
+				conf.Log = logs.DefaultConfig()
+			}
+			conf.Log.Level = *this.logLevel
+		}
+	})
+	return err
+}
+
+// proxy server子命令，返回进程的退出码
+func Main(name string, args []string) int {
+	f := newFlags(name)
+	if err := f.fs.Parse(args); err != nil {
+		return 2
+	}
+	load := func() (*Config, error) {
+		return LoadConfig(*f.config, f.apply)
+	}
+
+	conf, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *f.checkOnly {
+		fmt.Println("config ok")
+		return 0
+	}
+	if err := logs.Setup(conf.Log, "proxy"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logs.Info("loaded config", "config", utils.RedactConfig(conf))
+
+	s, err := New(conf)
+	if err != nil {
+		logs.Error("create server failed", "error", err)
+		return 1
+	}
+	logs.Info("starting the server", "version", utils.Version)
+	utils.RandomSeed()
+	if err := s.Start(); err != nil {
+		logs.Error("listen failed", "error", err)
+		return 1
+	}
+
+	// 重新读取配置文件
+	reload := func() error {
+		if *f.config == "" {
+			return fmt.Errorf("started without config file")
+		}
+		conf, err := load()
+		if err != nil {
+			return err
+		}
+		old := s.Config().Log
+		if err := s.Reload(conf); err != nil {
+			return err
+		}
+		return logs.Update(old, conf.Log, "proxy")
+	}
+	if conf.Admin != "" {
+		go s.serveAdmin(conf.Admin, reload)
+	}
+	if conf.Metrics != "" {
+		go metrics.Serve(conf.Metrics)
+	}
+
+	// 信号 和谐的退出，SIGHUP重新加载配置
+	exitMng := utils.NewExitManager()
+	exitMng.OnReload(func() {
+		if err := reload(); err != nil {
+			logs.Error("reload config failed", "error", err)
+		}
+	})
+	go exitMng.Run(s.Shutdown)
+
+	// 等待结束
+	s.Wait()
+	return 0
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/utils"
+)
+
+const (
+	PolicyReplace string = "replace" // 后注册的out顶替之前的（默认）
+	PolicyReject  string = "reject"  // 已经有out注册时拒绝后来者
+	PolicyLease   string = "lease"   // 只有持有相同租约或者证书身份的out才能顶替
+	PolicyShare   string = "share"   // 多个out共同服务，按照balance做负载均衡
+)
+
+// 单个Topic的访问控制
+type Topic struct {
+	Name    string   `json:"name" binding:"required"` // Topic名称
+	Outs    []string `json:"outs"`                    // 允许注册该Topic的out证书身份(CommonName)，为空不限制
+	Ins     []string `json:"ins"`                     // 允许访问该Topic的in证书身份(CommonName)，为空不限制
+	Token   string   `json:"token"`                   // 该Topic的认证密钥，为空则使用全局的token
+	Policy  string   `json:"policy"`                  // 同名Topic重复注册的策略，replace/reject/lease/share
+	Balance string   `json:"balance"`                 // share策略下的负载均衡，roundrobin/least/weighted
+}
+
+type Config struct {
+	Bind   string           `json:"bind" binding:"required"` // 绑定的本地主机
+	Port   uint16           `json:"port" binding:"required"` // 绑定的本地端口
+	TLS    *utils.TLSConfig `json:"tls"`                     // 证书配置，为空则使用明文
+	Topics []*Topic         `json:"topics"`                  // Topic访问控制
+	Token  string           `json:"token"`                   // 全局认证密钥，为空不认证
+
+	MaxFrameSize     int64 `json:"max_frame_size"`    // 单个报文的最大长度(字节）
+	HandshakeTimeout int   `json:"handshake_timeout"` // 握手报文的读超时(毫秒）
+	LeaseTimeout     int   `json:"lease_timeout"`     // lease策略下out掉线之后保留租约的时间(毫秒），期间只有原来的out可以注册
+
+	Admin      string `json:"admin"`       // 管理接口的监听地址，例如127.0.0.1:40010，为空不开启
+	AdminToken string `json:"admin_token"` // 管理接口的认证密钥，请求头X-Admin-Token，为空不认证
+	Metrics    string `json:"metrics"`     // 监控指标(/metrics)的监听地址，例如127.0.0.1:40011，为空不开启
+
+	Log    *logs.Config       `json:"log"`        // 日志配置
+	Access *logs.AccessConfig `json:"access_log"` // 访问日志，为空不开启
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Bind:             "127.0.0.1",
+		Port:             40001,
+		MaxFrameSize:     utils.MaxFrameSize,
+		HandshakeTimeout: utils.HandshakeTimeout,
+		LeaseTimeout:     utils.LeaseTimeout,
+		Log:              logs.DefaultConfig(),
+	}
+}
+
+// 依次使用默认值、配置文件（path为空则跳过）、环境变量(PROXY_*)和override（例如命令行参数，可以为空），最后校验
+func LoadConfig(path string, override func(conf *Config) error) (*Config, error) {
+	conf := DefaultConfig()
+	if path != "" {
+		if err := utils.ConfToStruct(path, conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := utils.EnvOverride("PROXY", conf); err != nil {
+		return nil, err
+	}
+	if override != nil {
+		if err := override(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// 校验配置，返回所有的错误（utils.ConfigErrors）
+func (this *Config) Validate() error {
+	v := utils.NewValidator()
+	v.Required("", this)
+	v.Host("bind", this.Bind)
+	v.TLS("tls", this.TLS, true)
+
+	names := make(map[string]int)
+	for i, t := range this.Topics {
+		path := utils.IndexPath("topics", i)
+		if t == nil {
+			v.Errorf(path, "null topic")
+			continue
+		}
+		if t.Name != "" {
+			v.Topic(utils.JoinPath(path, "name"), t.Name, true)
+			if j, ok := names[t.Name]; ok {
+				v.Errorf(utils.JoinPath(path, "name"), "duplicate topic [%s] with topics[%d]", t.Name, j)
+			}
+			names[t.Name] = i
+		}
+		if t.Policy != "" {
+			v.OneOf(utils.JoinPath(path, "policy"), t.Policy, PolicyReplace, PolicyReject, PolicyLease, PolicyShare)
+		}
+		if t.Balance != "" {
+			v.OneOf(utils.JoinPath(path, "balance"), t.Balance, BalanceRoundRobin, BalanceLeast, BalanceWeighted)
+		}
+	}
+
+	if this.MaxFrameSize <= 0 {
+		v.Errorf("max_frame_size", "must be positive")
+	}
+	if this.HandshakeTimeout <= 0 {
+		v.Errorf("handshake_timeout", "must be positive")
+	}
+	if this.LeaseTimeout <= 0 {
+		v.Errorf("lease_timeout", "must be positive")
+	}
+	if this.Admin != "" {
+		v.Addr("admin", this.Admin)
+	}
+	if this.Metrics != "" {
+		v.Addr("metrics", this.Metrics)
+	}
+	v.Log("log", this.Log)
+	v.Access("access_log", this.Access)
+	return v.Err()
+}
+
+// Topic的配置，优先精确匹配；没有时和路由一样选择最具体的通配配置，这样db.*.primary的配置
+// 同样作用于路由到它的db.mysql.primary。name本身是pattern（out注册的通配Topic）时只精确匹配
+func (this *Config) Topic(name string) *Topic {
+	var found *Topic
+	for _, v := range this.Topics {
+		if v.Name == name {
+			return v
+		}
+		if !utils.IsTopicPattern(v.Name) || utils.IsTopicPattern(name) || !utils.TopicMatch(v.Name, name) {
+			continue
+		}
+		if found == nil || utils.TopicMoreSpecific(v.Name, found.Name) {
+			found = v
+		}
+	}
+	return found
+}
+
+func allowIdentity(list []string, identity string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == "*" || v == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// out注册该Topic涉及的配置。通配Topic会收到所有和它重叠的Topic的请求，
+// 所以涉及所有重叠的配置（包括同名的），而不只是同名的那一个
+func (this *Config) outTopics(topic string) []*Topic {
+	if !utils.IsTopicPattern(topic) {
+		if t := this.Topic(topic); t != nil {
+			return []*Topic{t}
+		}
+		return nil
+	}
+	list := make([]*Topic, 0)
+	for _, v := range this.Topics {
+		if v.Name == topic || utils.TopicOverlap(v.Name, topic) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// 校验out证书身份是否允许注册该Topic，通配Topic需要被所有重叠的Topic允许
+func (this *Config) AllowOut(topic, identity string) bool {
+	for _, t := range this.outTopics(topic) {
+		if !allowIdentity(t.Outs, identity) {
+			return false
+		}
+	}
+	return true
+}
+
+// out注册该Topic需要满足的认证密钥（去重），通配Topic重叠的Topic密钥不同时有多个，无法同时满足
+func (this *Config) OutTokens(topic string) []string {
+	tokens := make([]string, 0, 1)
+	for _, t := range this.outTopics(topic) {
+		token := t.Token
+		if token == "" {
+			token = this.Token
+		}
+		found := false
+		for _, v := range tokens {
+			found = found || v == token
+		}
+		if !found {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		tokens = append(tokens, this.Token)
+	}
+	return tokens
+}
+
+// 校验in证书身份是否允许访问该Topic
+func (this *Config) AllowIn(topic, identity string) bool {
+	t := this.Topic(topic)
+	if t == nil {
+		return true
+	}
+	return allowIdentity(t.Ins, identity)
+}
+
+// Topic的认证密钥
+func (this *Config) TopicToken(topic string) string {
+	if t := this.Topic(topic); t != nil && t.Token != "" {
+		return t.Token
+	}
+	return this.Token
+}
+
+// 策略的严格程度，通配Topic取所有重叠Topic中最严格的
+var policyRanks = map[string]int{
+	PolicyShare:   0,
+	PolicyReplace: 1,
+	PolicyLease:   2,
+	PolicyReject:  3,
+}
+
+// Topic重复注册的策略
+func (this *Config) TopicPolicy(topic string) string {
+	policy := ""
+	for _, t := range this.outTopics(topic) {
+		if t.Policy != "" && (policy == "" || policyRanks[t.Policy] > policyRanks[policy]) {
+			policy = t.Policy
+		}
+	}
+	if policy == "" {
+		return PolicyReplace
+	}
+	return policy
+}
+
+// Topic的负载均衡策略
+func (this *Config) TopicBalance(topic string) string {
+	if t := this.Topic(topic); t != nil && t.Balance != "" {
+		return t.Balance
+	}
+	return BalanceRoundRobin
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/qjw/proxy/utils"
+)
+
+func TestConfigTopicPattern(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Token = "global"
+	conf.Topics = []*Topic{
+		{Name: "db.#", Token: "db"},
+		{Name: "db.*.primary", Ins: []string{"app"}, Token: "primary", Balance: BalanceLeast},
+		{Name: "db.mysql.primary", Token: "mysql"},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		topic string
+		token string
+	}{
+		{"db.mysql.primary", "mysql"},
+		{"db.pg.primary", "primary"},
+		{"db.pg.replica", "db"},
+		{"db", "db"},
+		{"web", "global"},
+		// out注册的pattern只精确匹配
+		{"db.*.primary", "primary"},
+		{"db.*.replica", "global"},
+	}
+	for _, c := range cases {
+		if token := conf.TopicToken(c.topic); token != c.token {
+			t.Errorf("TopicToken(%s) = %s, expect %s", c.topic, token, c.token)
+		}
+	}
+
+	if conf.AllowIn("db.pg.primary", "web") {
+		t.Error("identity web should not access db.pg.primary")
+	}
+	if !conf.AllowIn("db.pg.primary", "app") {
+		t.Error("identity app should access db.pg.primary")
+	}
+	if !conf.AllowIn("db.pg.replica", "web") {
+		t.Error("db.pg.replica is not restricted")
+	}
+	if b := conf.TopicBalance("db.pg.primary"); b != BalanceLeast {
+		t.Errorf("TopicBalance = %s, expect %s", b, BalanceLeast)
+	}
+}
+
+// out注册的pattern要满足所有重叠的Topic的配置
+func TestConfigOutPattern(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Token = "global"
+	conf.Topics = []*Topic{
+		{Name: "secret", Outs: []string{"good"}, Token: "s", Policy: PolicyLease},
+		{Name: "x.secret", Outs: []string{"good", "other"}, Token: "s", Policy: PolicyReject},
+		{Name: "cache", Token: "c"},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		topic    string
+		identity string
+		allow    bool
+		tokens   []string
+		policy   string
+	}{
+		{"#.secret", "evil", false, []string{"s"}, PolicyReject},
+		{"#.secret", "good", true, []string{"s"}, PolicyReject},
+		{"*.secret", "other", true, []string{"s"}, PolicyReject},
+		{"*.db", "evil", true, []string{"global"}, PolicyReplace},
+		{"#.db", "evil", true, []string{"global"}, PolicyReplace},
+		{"#", "good", true, []string{"s", "c"}, PolicyReject},
+		// 具体的Topic只用自己的配置
+		{"secret", "other", false, []string{"s"}, PolicyLease},
+		{"web", "evil", true, []string{"global"}, PolicyReplace},
+	}
+	for _, c := range cases {
+		if allow := conf.AllowOut(c.topic, c.identity); allow != c.allow {
+			t.Errorf("AllowOut(%s, %s) = %v, expect %v", c.topic, c.identity, allow, c.allow)
+		}
+		if tokens := conf.OutTokens(c.topic); !reflect.DeepEqual(tokens, c.tokens) {
+			t.Errorf("OutTokens(%s) = %v, expect %v", c.topic, tokens, c.tokens)
+		}
+		if policy := conf.TopicPolicy(c.topic); policy != c.policy {
+			t.Errorf("TopicPolicy(%s) = %s, expect %s", c.topic, policy, c.policy)
+		}
+	}
+}
+
+// 证书身份的白名单：为空不限制，*允许任意身份
+func TestConfigIdentity(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Topics = []*Topic{
+		{Name: "db", Outs: []string{"db-out"}, Ins: []string{"app", "admin"}},
+		{Name: "web", Ins: []string{"*"}},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		topic    string
+		identity string
+		out      bool
+		in       bool
+	}{
+		{"db", "db-out", true, false},
+		{"db", "app", false, true},
+		{"db", "admin", false, true},
+		{"db", "", false, false},
+		{"web", "anyone", true, true},
+		{"web", "", true, true},
+		{"other", "", true, true},
+	}
+	for _, c := range cases {
+		if got := conf.AllowOut(c.topic, c.identity); got != c.out {
+			t.Errorf("AllowOut(%s, %q) = %v, expect %v", c.topic, c.identity, got, c.out)
+		}
+		if got := conf.AllowIn(c.topic, c.identity); got != c.in {
+			t.Errorf("AllowIn(%s, %q) = %v, expect %v", c.topic, c.identity, got, c.in)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Bind = "bad host"
+	conf.Topics = []*Topic{
+		{Name: "db", Policy: "steal"},
+		nil,
+		{Name: "db", Balance: "random"},
+		{Outs: []string{"a"}},
+		{Name: "#"},
+	}
+	conf.MaxFrameSize = 0
+	conf.Admin = "127.0.0.1"
+	conf.TLS = &utils.TLSConfig{CA: "ca.pem"}
+
+	expect := []string{
+		"admin",
+		"bind",
+		"max_frame_size",
+		"tls.cert",
+		"topics[0].policy",
+		"topics[1]",
+		"topics[2].balance",
+		"topics[2].name",
+		"topics[3].name",
+		"topics[4].name",
+	}
+	var errs utils.ConfigErrors
+	if err := conf.Validate(); !errors.As(err, &errs) {
+		t.Fatalf("expect ConfigErrors, got %v", err)
+	}
+	paths := make([]string, 0, len(errs))
+	for _, v := range errs {
+		paths = append(paths, v.Path)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("got %v, expect %v", paths, expect)
+	}
+}
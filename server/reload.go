@@ -0,0 +1,48 @@
+package server
+
+import (
+	"reflect"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/utils"
+)
+
+// 替换配置（SIGHUP或者管理接口/reload）
+//
+// Topic的访问控制、认证密钥、报文长度、握手超时和证书文件对之后的握手立即生效，
+// 已经建立的连接不受影响；监听地址、是否开启证书、管理接口、监控指标和访问日志需要重启
+func (this *Server) Reload(conf *Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	this.reloadLock.Lock()
+	defer this.reloadLock.Unlock()
+
+	old := this.Config()
+	if conf.Bind != old.Bind || conf.Port != old.Port ||
+		(conf.TLS == nil) != (old.TLS == nil) ||
+		conf.Admin != old.Admin || conf.Metrics != old.Metrics ||
+		!reflect.DeepEqual(conf.Access, old.Access) {
+		logs.Warn("changes of bind/port/tls/admin/metrics/access_log require restart, ignored")
+		conf.Bind, conf.Port = old.Bind, old.Port
+		conf.Admin, conf.Metrics, conf.Access = old.Admin, old.Metrics, old.Access
+		if (conf.TLS == nil) != (old.TLS == nil) {
+			conf.TLS = old.TLS
+		}
+	}
+
+	// 证书文件可能更新了（即使路径没有变化），总是重新加载
+	if conf.TLS != nil {
+		tlsConf, err := utils.ServerTLS(conf.TLS)
+		if err != nil {
+			return err
+		}
+		this.tls.Store(tlsConf)
+	}
+
+	msg.SetMaxFrameSize(conf.MaxFrameSize)
+	this.conf.Store(conf)
+	logs.Info("config reloaded", "topics", len(conf.Topics))
+	return nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/utils"
+)
+
+func TestReload(t *testing.T) {
+	defer msg.SetMaxFrameSize(utils.MaxFrameSize)
+
+	conf := DefaultConfig()
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 立即生效的配置
+	conf2 := *conf
+	conf2.Token = "new"
+	conf2.Topics = []*Topic{{Name: "db", Policy: PolicyReject}}
+	conf2.MaxFrameSize = 1024
+	conf2.LeaseTimeout = 1000
+	// 需要重启的配置，保持原来的值
+	conf2.Bind = "0.0.0.0"
+	conf2.Port = 50001
+	conf2.Admin = "127.0.0.1:40010"
+	conf2.Metrics = "127.0.0.1:40011"
+	conf2.Access = &logs.AccessConfig{Output: "access.log"}
+	if err := s.Reload(&conf2); err != nil {
+		t.Fatal(err)
+	}
+
+	got := s.Config()
+	if got.Token != "new" || got.TopicPolicy("db") != PolicyReject || got.MaxFrameSize != 1024 || got.LeaseTimeout != 1000 {
+		t.Fatalf("live fields not applied: %+v", got)
+	}
+	if got.Bind != conf.Bind || got.Port != conf.Port || got.Admin != "" || got.Metrics != "" || got.Access != nil {
+		t.Fatalf("restart-required fields should be kept: %+v", got)
+	}
+
+	// 非法的配置不替换
+	conf3 := *got
+	conf3.Token = "bad"
+	conf3.MaxFrameSize = 0
+	if err := s.Reload(&conf3); err == nil {
+		t.Fatal("invalid config should be refused")
+	}
+	if s.Config().Token != "new" {
+		t.Fatal("config replaced by invalid one")
+	}
+
+	// 开启证书同样需要重启
+	conf4 := *s.Config()
+	conf4.TLS = &utils.TLSConfig{Cert: "missing.pem", Key: "missing.key"}
+	if err := s.Reload(&conf4); err != nil {
+		t.Fatal(err)
+	}
+	if s.Config().TLS != nil {
+		t.Fatal("enabling tls should require restart")
+	}
+}
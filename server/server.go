@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/utils"
+)
+
+// proxy服务，可以嵌入到其他程序中：New之后Start，Shutdown之后Wait等待所有连接结束
+//
+// 报文的最大长度(max_frame_size)是进程全局的，同一个进程中的多个Server以最后设置的为准
+type Server struct {
+	conf       atomic.Pointer[Config]     // 重新加载配置时整体替换
+	tls        atomic.Pointer[tls.Config] // 当前使用的证书，重新加载配置时替换，新的连接生效
+	replay     *utils.ReplayCache
+	access     *logs.AccessLog // 访问日志，没有配置时为nil
+	reloadLock sync.Mutex      // SIGHUP和管理接口可能同时触发
+
+	p        *ProxyRegistry
+	c        *ControlRegistry
+	listener net.Listener
+	done     chan bool // accept结束
+}
+
+// 校验配置并打开访问日志
+func New(conf *Config) (*Server, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	access, err := logs.OpenAccessLog(conf.Access, "proxy")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		replay: utils.NewReplayCache(),
+		access: access,
+		done:   make(chan bool),
+	}
+	s.conf.Store(conf)
+	s.p = NewProxyRegistry(s)
+	s.c = NewControlRegistry(s)
+	return s, nil
+}
+
+// 当前的配置，不要修改
+func (this *Server) Config() *Config {
+	return this.conf.Load()
+}
+
+// 监听端口并在后台接受连接
+func (this *Server) Start() error {
+	conf := this.Config()
+	msg.SetMaxFrameSize(conf.MaxFrameSize)
+
+	// tcp服务器
+	listener, err := net.Listen(
+		"tcp",
+		net.JoinHostPort(conf.Bind, fmt.Sprintf("%d", conf.Port)),
+	)
+	if err != nil {
+		return err
+	}
+	if conf.TLS != nil {
+		tlsConf, err := utils.ServerTLS(conf.TLS)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		this.tls.Store(tlsConf)
+		listener = tls.NewListener(listener, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return this.tls.Load(), nil
+			},
+		})
+	}
+	this.listener = listener
+	addServer(this)
+
+	go this.serve()
+	return nil
+}
+
+// 监听的地址
+func (this *Server) Addr() net.Addr {
+	return this.listener.Addr()
+}
+
+func (this *Server) serve() {
+	defer close(this.done)
+	for {
+		conn, err := this.listener.Accept()
+		if conn == nil {
+			logs.Info("listener accept ended")
+			break
+		}
+		if err != nil {
+			logs.Error("accept failed", "error", err)
+			break
+		}
+
+		go this.handle(conn)
+	}
+}
+
+// 停止监听并断开所有的连接
+func (this *Server) Shutdown() {
+	this.listener.Close()
+	this.p.Shutdown()
+	this.c.Shutdown()
+}
+
+// 等待所有的连接结束
+func (this *Server) Wait() {
+	<-this.done
+	this.p.WaitComplele()
+	this.c.WaitComplele()
+	delServer(this)
+	this.access.Close()
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/qjw/proxy/metrics"
+)
+
+// 监控指标，配置metrics之后以Prometheus文本格式暴露
+var (
+	sessionsStarted = metrics.NewCounter("proxy_sessions_started_total",
+		"Sessions successfully bridged between in and out.", "topic")
+	sessionsFailed = metrics.NewCounter("proxy_sessions_failed_total",
+		"Sessions refused or failed before data exchange.", "topic", "reason")
+	sessionBytes = metrics.NewCounter("proxy_bytes_total",
+		"Bytes exchanged by finished sessions, direction in (in->out) or out (out->in).", "topic", "direction")
+	freeTunnelTimeouts = metrics.NewCounter("proxy_free_tunnel_timeouts_total",
+		"Timeouts waiting for a free data connection from out.", "topic")
+	handshakeErrors = metrics.NewCounter("proxy_handshake_errors_total",
+		"Handshakes refused, by request type and reason.", "request", "reason")
+	registrations = metrics.NewCounter("proxy_registrations_total",
+		"Successful out registrations, including reconnects.", "topic")
+)
+
+// 失败的原因，作为reason标签，也用作访问日志中连接结束的原因
+const (
+	reasonRead           = "read"
+	reasonVersion        = "version"
+	reasonTopic          = "topic"
+	reasonIdentity       = "identity"
+	reasonAuth           = "auth"
+	reasonRefused        = "refused"
+	reasonNoTunnel       = "no_tunnel"
+	reasonPoolFull       = "pool_full"
+	reasonTimeout        = "timeout"
+	reasonTunnelShutdown = "tunnel_shutdown"
+	reasonBackendDial    = "backend_dial"
+	reasonActivate       = "activate"
+	reasonInvalid        = "invalid_request"
+	reasonTarget         = "target"
+
+	// 数据交换开始之后
+	reasonClientEOF     = "client_eof"
+	reasonClientError   = "client_error"
+	reasonUpstreamEOF   = "upstream_eof"
+	reasonUpstreamError = "upstream_error"
+	reasonKicked        = "kicked"
+	reasonShutdown      = "shutdown"
+)
+
+// in请求的Topic没有注册也没有配置时使用的标签
+const otherTopic = "other"
+
+// 未知的请求类型使用的request标签
+const unknownRequest = "unknown"
+
+// 监控指标的topic标签：服务该proxy的tunnel注册的Topic（通配路由时为pattern），或者配置中同名的Topic，
+// 其他的都归为other。in请求的Topic未经认证，直接作为标签会产生无限多的序列
+func (this *proxy) topicLabel() string {
+	if t := this.Tunnel(); t != nil {
+		return t.Type()
+	}
+	for _, v := range this.r.s.Config().Topics {
+		if v.Name == this.m.Type {
+			return v.Name
+		}
+	}
+	return otherTopic
+}
+
+// 正在运行的Server，gauge抓取时汇总
+var (
+	serversLock sync.Mutex
+	servers     = make(map[*Server]bool)
+)
+
+func addServer(s *Server) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	servers[s] = true
+}
+
+func delServer(s *Server) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	delete(servers, s)
+}
+
+func runningServers() []*Server {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	list := make([]*Server, 0, len(servers))
+	for s := range servers {
+		list = append(list, s)
+	}
+	return list
+}
+
+// 依赖registry的gauge，抓取时计算
+var (
+	_ = metrics.NewGaugeFunc("proxy_tunnels",
+		"Registered out tunnels (including standbys).", func() []metrics.Sample {
+			return topicSamples(func(t *tunnel) int { return 1 })
+		}, "topic")
+	_ = metrics.NewGaugeFunc("proxy_free_tunnels",
+		"Idle data connections in the free pool.", func() []metrics.Sample {
+			return topicSamples(func(t *tunnel) int { return len(t.frees) })
+		}, "topic")
+	_ = metrics.NewGaugeFunc("proxy_active_sessions",
+		"Sessions currently forwarding.", func() []metrics.Sample {
+			sum := 0
+			for _, s := range runningServers() {
+				s.p.Lock()
+				sum += len(s.p.proxies)
+				s.p.Unlock()
+			}
+			return []metrics.Sample{{Value: float64(sum)}}
+		})
+)
+
+// 按照Topic累加每个tunnel的值
+func topicSamples(fn func(t *tunnel) int) []metrics.Sample {
+	sums := make(map[string]int)
+	for _, s := range runningServers() {
+		s.c.topicSums(sums, fn)
+	}
+	samples := make([]metrics.Sample, 0, len(sums))
+	for tp, sum := range sums {
+		samples = append(samples, metrics.Sample{Labels: []string{tp}, Value: float64(sum)})
+	}
+	return samples
+}
+
+func (this *ControlRegistry) topicSums(sums map[string]int, fn func(t *tunnel) int) {
+	this.RLock()
+	defer this.RUnlock()
+
+	for tp, set := range this.tunnels {
+		for _, list := range [][]*tunnel{set.tunnels, set.standbys} {
+			for _, t := range list {
+				sums[tp] += fn(t)
+			}
+		}
+	}
+}
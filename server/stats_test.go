@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/qjw/proxy/metrics"
+	"github.com/qjw/proxy/msg"
+)
+
+func TestTopicLabel(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Topics = []*Topic{{Name: "web"}, {Name: "db.#"}}
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		topic  string
+		tunnel string
+		label  string
+	}{
+		{"web", "", "web"},
+		{"random-1234", "", otherTopic},
+		// 只有通配的配置时不能区分，仍然归为other
+		{"db.mysql", "", otherTopic},
+		{"db.mysql", "db.#", "db.#"},
+		{"api", "api", "api"},
+	}
+	for _, c := range cases {
+		p := &proxy{r: s.p, m: &msg.InRequest{Type: c.topic}}
+		if c.tunnel != "" {
+			p.t = testTunnel(c.tunnel, "", "", false)
+		}
+		if label := p.topicLabel(); label != c.label {
+			t.Errorf("topicLabel(%s, %s) = %s, expect %s", c.topic, c.tunnel, label, c.label)
+		}
+	}
+}
+
+// 未知的请求类型记为request="unknown"，不使用Topic的标签
+func TestHandshakeErrorUnknownRequest(t *testing.T) {
+	s, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	defer a.Close()
+	go s.handle(b)
+
+	if err := msg.WriteMsg(a, &msg.Ping{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := msg.ReadMsg(a); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	metrics.Default.WriteTo(&buf)
+	if !strings.Contains(buf.String(), `proxy_handshake_errors_total{request="unknown",reason="invalid_request"}`) {
+		t.Fatalf("unknown request not counted:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,1089 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/mux"
+	"github.com/qjw/proxy/utils"
+)
+
+const maxSessionLen = 64
+
+var (
+	errTunnelClosing = fmt.Errorf("No proxy connections available, control is closing")
+	errTunnelTimeout = fmt.Errorf("Timeout trying to get proxy connection")
+)
+
+// 校验握手签名
+func (this *Server) checkAuth(caps *utils.Capabilities, tokens []string, magic, tp string, ts int64, sign string) error {
+	if len(tokens) > 1 {
+		return fmt.Errorf("topic [%s] overlaps topics with different tokens", tp)
+	}
+	token := tokens[0]
+	if token != "" && !caps.Has(utils.FeatureAuth) {
+		return fmt.Errorf("authentication required, peer version %s is too old", caps.Version)
+	}
+	if err := utils.CheckSign(token, magic, tp, ts, sign); err != nil {
+		return err
+	}
+	if sign != "" && !this.replay.Check(magic) {
+		return fmt.Errorf("authentication replayed")
+	}
+	return nil
+}
+
+type tunnel struct {
+	r   *ControlRegistry
+	m   *msg.OutRequest // 控制连接的请求包
+	mng net.Conn        // 控制连接
+
+	proxyLock sync.Mutex
+	proxies   map[*proxy]int // 已经正在工作的转发proxy
+
+	frees chan net.Conn      // 空闲数据链接
+	out   chan (msg.Message) // 接收控制指令的ch
+
+	caps          *utils.Capabilities // 协商的版本和特性
+	codec         msg.Codec           // 协商的编码
+	lease         string              // 注册时分配的租约
+	identity      string              // out的证书身份
+	currentWeight int                 // 加权轮询的当前权重，由ControlRegistry的锁保护
+	id            string              // 唯一标识，用于管理接口
+	created       time.Time           // 注册时间
+	log           *logs.Logger        // 附带tunnel、topic、remote字段
+
+	shutdown     *utils.Shutdown // 关于tunnel自己的控制器
+	loopShutdown *utils.Shutdown // 关于loop go routine的控制器
+	readShutdown *utils.Shutdown // 关于read go routine的控制器
+}
+
+func (this *tunnel) Type() string {
+	return this.m.Type
+}
+
+func (this *tunnel) Weight() int {
+	if this.m.Weight <= 0 {
+		return 1
+	}
+	return this.m.Weight
+}
+
+// in请求的压缩算法out是否接受，不接受时不压缩
+func (this *tunnel) Compress(caps *utils.Capabilities, method string) string {
+	if method == "" || !caps.Has(utils.FeatureCompress) || !this.caps.Has(utils.FeatureCompress) {
+		return ""
+	}
+	for _, v := range this.m.Compress {
+		if v == method {
+			return method
+		}
+	}
+	return ""
+}
+
+func (this *tunnel) ProxyCount() int {
+	this.proxyLock.Lock()
+	defer this.proxyLock.Unlock()
+	return len(this.proxies)
+}
+
+func (this *tunnel) RequestNewTunnel() {
+	this.out <- &msg.NewDataRequest{
+		Magic: utils.Magic(),
+		Type:  this.m.Type,
+	}
+}
+
+func (this *tunnel) GetFreeTunnel() (conn net.Conn, err error) {
+	var ok bool
+	select {
+	case conn, ok = <-this.frees:
+		if !ok {
+			err = errTunnelClosing
+			return
+		}
+	default:
+		this.log.Debug("no free tunnel in pool, requesting tunnel from control")
+		this.RequestNewTunnel()
+
+		select {
+		case conn, ok = <-this.frees:
+			if !ok {
+				err = errTunnelClosing
+				return
+			}
+		case <-time.After(time.Duration(utils.FreeTunnelTimeout) * time.Millisecond):
+			freeTunnelTimeouts.Inc(this.m.Type)
+			err = errTunnelTimeout
+			return
+		}
+	}
+	this.log.Debug("get a free data conn", "data", conn.RemoteAddr().String())
+	// 被弄走一个，提前再申请一个
+	this.RequestNewTunnel()
+	return
+}
+
+func (this *tunnel) RegisterDataConn(conn net.Conn) error {
+	select {
+	case this.frees <- conn:
+		this.log.Debug("registered data conn", "data", conn.RemoteAddr().String())
+		return nil
+	default:
+		this.log.Warn("frees buffer is full, discarding")
+		return fmt.Errorf("frees buffer is full, discarding.")
+	}
+}
+
+func (this *tunnel) Add(s *proxy) {
+	this.log.Debug("add session to tunnel", "session", s.id)
+	this.proxyLock.Lock()
+	defer this.proxyLock.Unlock()
+
+	if _, ok := this.proxies[s]; !ok {
+		this.proxies[s] = 0
+	} else {
+		panic("repeat add to tunnel")
+	}
+}
+
+func (this *tunnel) Del(s *proxy) {
+	this.log.Debug("del session from tunnel", "session", s.id)
+	this.proxyLock.Lock()
+	defer this.proxyLock.Unlock()
+
+	if _, ok := this.proxies[s]; ok {
+		delete(this.proxies, s)
+	} else {
+		panic("empty del from tunnel")
+	}
+}
+
+func (this *tunnel) loop() {
+	if this.mng == nil {
+		panic("invalid tunnel")
+	}
+	defer this.loopShutdown.Complete()
+	defer this.Shutdown()
+
+	// write messages to the control channel
+	for m := range this.out {
+		if err := msg.WriteMsgWith(this.mng, this.codec, m); err != nil {
+			this.log.Warn("write control message failed", "error", err)
+			break
+		}
+	}
+}
+
+func (this *tunnel) read() {
+	if this.mng == nil {
+		panic("invalid tunnel")
+	}
+	defer this.readShutdown.Complete()
+	defer this.Shutdown()
+
+	for {
+		d, tp, err := msg.ReadMsg(this.mng)
+		if err != nil {
+			if err == io.EOF {
+				this.log.Info("control connection closed by out")
+			} else {
+				this.log.Warn("read control message failed", "error", err)
+			}
+			break
+		}
+		if tp == "Ping" {
+			// 自动回个心跳
+			_, ok := d.(*msg.Ping)
+			if !ok {
+				this.log.Warn("invalid Ping message")
+				break
+			}
+			msg.WriteMsgWith(this.mng, this.codec, &msg.Pong{})
+		}
+	}
+}
+
+func (this *tunnel) Shutdown() {
+	this.shutdown.Begin()
+}
+
+func (this *tunnel) Run() {
+	// 注册在NewTunnel中完成
+	defer this.r.running.Done()
+
+	go this.loop()
+	go this.read()
+
+	// 事先请求一个data通道
+	this.RequestNewTunnel()
+
+	// 等待结束指令
+	this.shutdown.WaitBegin()
+	this.log.Info("start to shutdown tunnel")
+
+	// 尽早注销，备用的tunnel可以立即接替
+	this.r.Del(this)
+
+	// 关闭接收控制指令的ch
+	close(this.out)
+
+	// 关闭socket
+	utils.CloseReadWrite(this.mng)
+	this.mng.Close()
+
+	// 关闭空闲的连接
+	close(this.frees)
+	for p := range this.frees {
+		this.log.Debug("close free data conn", "data", p.RemoteAddr().String())
+		p.Close()
+	}
+
+	// 关闭关联的proxy
+	this.proxyLock.Lock()
+	for t, _ := range this.proxies {
+		this.log.Debug("start to shutdown session", "session", t.id)
+		t.Terminate(reasonTunnelShutdown, "")
+	}
+	this.proxyLock.Unlock()
+
+	// 等待go routine结束
+	this.loopShutdown.WaitComplete()
+	this.readShutdown.WaitComplete()
+	this.shutdown.Complete()
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+// lease策略下掉线的out保留的租约，过期之前只有原来的out可以注册
+type leaseReservation struct {
+	lease    string
+	identity string
+	expire   time.Time
+}
+
+type ControlRegistry struct {
+	s        *Server
+	tunnels  map[string]*tunnelSet
+	reserved map[string]*leaseReservation // 按Topic保留的租约
+	running  sync.WaitGroup               // 尚未结束的tunnel，注销之后tunnel仍在关闭中
+	sync.RWMutex
+}
+
+func NewControlRegistry(s *Server) *ControlRegistry {
+	return &ControlRegistry{
+		s:        s,
+		tunnels:  make(map[string]*tunnelSet),
+		reserved: make(map[string]*leaseReservation),
+	}
+}
+
+// 注册新的tunnel，注册成功之后由调用者回复out并执行Run
+func (this *ControlRegistry) NewTunnel(mng net.Conn, m *msg.OutRequest, caps *utils.Capabilities) (*tunnel, error) {
+	// 对端不支持lease特性时忽略租约、备用和权重，和旧版本一样注册
+	if !caps.Has(utils.FeatureLease) {
+		m.Lease, m.Standby, m.Weight = "", false, 0
+	}
+	t := &tunnel{
+		r:            this,
+		mng:          mng,
+		m:            m,
+		proxies:      make(map[*proxy]int),
+		frees:        make(chan net.Conn, utils.TunnelBufLen),
+		shutdown:     utils.NewShutdown(true),
+		loopShutdown: utils.NewShutdown(false),
+		readShutdown: utils.NewShutdown(false),
+		out:          make(chan msg.Message),
+		identity:     utils.PeerIdentity(mng),
+		caps:         caps,
+		codec:        msg.JSON,
+		id:           utils.RandId(8),
+		created:      time.Now(),
+	}
+	t.log = logs.With("tunnel", t.id, "topic", m.Type, "remote", mng.RemoteAddr().String())
+	if caps.Has(utils.FeatureCodec) {
+		t.codec = msg.NegotiateCodec(m.Codecs)
+	}
+	if err := this.Add(t); err != nil {
+		return nil, err
+	}
+	this.running.Add(1)
+	return t, nil
+}
+
+func (this *ControlRegistry) Add(s *tunnel) error {
+	s.log.Debug("add tunnel")
+	this.Lock()
+	defer this.Unlock()
+
+	tp := s.Type()
+	set, ok := this.tunnels[tp]
+	if !ok {
+		set = &tunnelSet{}
+		this.tunnels[tp] = set
+	}
+
+	s.lease = utils.SecureRandIdOrPanic(utils.MagicLen)
+	policy := this.s.Config().TopicPolicy(tp)
+	if policy == PolicyLease && len(set.tunnels) == 0 {
+		if err := this.checkReserved(tp, s); err != nil {
+			return err
+		}
+	}
+	if s.m.Standby && policy != PolicyShare {
+		if err := checkStandby(set, s, policy); err != nil {
+			return err
+		}
+	}
+	if s.m.Standby || policy == PolicyShare {
+		// 多个out共同服务（或者备用），只顶替自己之前的tunnel
+		if t := set.find(s.m.Lease); t != nil {
+			s.lease = t.lease
+			set.del(t)
+			t.Shutdown()
+		}
+		set.add(s)
+		this.promote(set)
+		return nil
+	}
+
+	if len(set.tunnels) > 0 {
+		t := set.tunnels[0]
+		switch policy {
+		case PolicyReject:
+			return fmt.Errorf("topic [%s] is owned by another out", tp)
+		case PolicyLease:
+			sameLease := s.m.Lease != "" && s.m.Lease == t.lease
+			sameOwner := s.identity != "" && s.identity == t.identity
+			if !sameLease && !sameOwner {
+				return fmt.Errorf("topic [%s] is leased by another out", tp)
+			}
+			// 续用原来的租约
+			s.lease = t.lease
+		}
+
+		// 关闭旧的
+		for _, v := range set.tunnels {
+			v.Shutdown()
+		}
+		set.tunnels = nil
+	}
+	set.add(s)
+	return nil
+}
+
+// reject/lease策略同样约束备用的out，否则备用的out被提升之后就绕过了策略：
+// 已经有out注册时，只能顶替自己之前的tunnel，lease策略下还可以是和已有out相同的证书身份
+func checkStandby(set *tunnelSet, s *tunnel, policy string) error {
+	if set.empty() || set.find(s.m.Lease) != nil {
+		return nil
+	}
+	switch policy {
+	case PolicyReject:
+		return fmt.Errorf("topic [%s] is owned by another out", s.Type())
+	case PolicyLease:
+		for _, list := range [][]*tunnel{set.tunnels, set.standbys} {
+			for _, t := range list {
+				if s.identity != "" && s.identity == t.identity {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("topic [%s] is leased by another out", s.Type())
+	}
+	return nil
+}
+
+// 保留的租约没有过期时，只有持有相同租约或者证书身份的out可以注册，并且续用原来的租约。调用者持有锁
+func (this *ControlRegistry) checkReserved(tp string, s *tunnel) error {
+	r, ok := this.reserved[tp]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(r.expire) {
+		delete(this.reserved, tp)
+		return nil
+	}
+	sameLease := s.m.Lease != "" && s.m.Lease == r.lease
+	sameOwner := s.identity != "" && s.identity == r.identity
+	if !sameLease && !sameOwner {
+		return fmt.Errorf("topic [%s] is reserved for the previous lease holder", tp)
+	}
+	s.lease = r.lease
+	delete(this.reserved, tp)
+	return nil
+}
+
+// 调用者持有锁
+func (this *ControlRegistry) promote(set *tunnelSet) {
+	if t := set.promote(); t != nil {
+		t.log.Info("promote standby tunnel")
+	}
+}
+
+func (this *ControlRegistry) Del(s *tunnel) {
+	s.log.Debug("del tunnel")
+	this.Lock()
+	defer this.Unlock()
+
+	tp := s.Type()
+	if set, ok := this.tunnels[tp]; ok {
+		removed := set.del(s)
+		if removed {
+			s.log.Info("tunnel unregistered")
+		} else {
+			s.log.Debug("tunnel already replaced")
+		}
+		this.promote(set)
+
+		// lease策略下没有out接替时保留租约，避免out正常重连的间隙被其他out抢占
+		conf := this.s.Config()
+		if removed && len(set.tunnels) == 0 && conf.TopicPolicy(tp) == PolicyLease {
+			timeout := time.Duration(conf.LeaseTimeout) * time.Millisecond
+			this.reserved[tp] = &leaseReservation{
+				lease:    s.lease,
+				identity: s.identity,
+				expire:   time.Now().Add(timeout),
+			}
+			s.log.Info("lease reserved", "timeout", timeout.String())
+		}
+		if set.empty() {
+			delete(this.tunnels, tp)
+		}
+	} else {
+		s.log.Debug("tunnel already replaced")
+	}
+}
+
+// 按照Topic的负载均衡策略选择一个tunnel，没有同名的tunnel时路由到最具体的通配Topic
+func (this *ControlRegistry) Get(tp string) *tunnel {
+	this.Lock()
+	defer this.Unlock()
+
+	name := tp
+	set, ok := this.tunnels[name]
+	if !ok {
+		for k, v := range this.tunnels {
+			if !utils.IsTopicPattern(k) || !utils.TopicMatch(k, tp) {
+				continue
+			}
+			if !ok || utils.TopicMoreSpecific(k, name) {
+				name, set, ok = k, v, true
+			}
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return set.pick(this.s.Config().TopicBalance(name))
+}
+
+// 查找数据连接所属的tunnel，lease为空（旧版本的out）时返回第一个
+func (this *ControlRegistry) Find(tp, lease string) *tunnel {
+	this.RLock()
+	defer this.RUnlock()
+
+	set, ok := this.tunnels[tp]
+	if !ok || set.empty() {
+		return nil
+	}
+	if lease == "" {
+		if len(set.tunnels) > 0 {
+			return set.tunnels[0]
+		}
+		return set.standbys[0]
+	}
+	return set.find(lease)
+}
+
+func (this *ControlRegistry) Shutdown() {
+	logs.Info("start to shutdown ControlRegistry")
+	this.Lock()
+	defer this.Unlock()
+
+	// 尝试关闭
+	for _, set := range this.tunnels {
+		for _, v := range set.tunnels {
+			v.Shutdown()
+		}
+		for _, v := range set.standbys {
+			v.Shutdown()
+		}
+	}
+
+}
+
+func (this *ControlRegistry) WaitComplele() {
+	for {
+		time.Sleep(time.Millisecond * 100)
+		this.Lock()
+
+		if len(this.tunnels) == 0 {
+			this.Unlock()
+			break
+		}
+
+		this.Unlock()
+	}
+	this.running.Wait()
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+type proxy struct {
+	r            *ProxyRegistry
+	m            *msg.InRequest
+	caps         *utils.Capabilities // 和in协商的版本和特性
+	cli          net.Conn
+	svr          net.Conn
+	shutdown     *utils.Shutdown
+	loopShutdown *utils.Shutdown
+
+	id       string       // 唯一标识，用于管理接口
+	created  time.Time    // 开始时间
+	bytesIn  int64        // in发往out的字节数，原子操作
+	bytesOut int64        // out发往in的字节数，原子操作
+	log      *logs.Logger // 附带session、topic、remote、magic字段
+
+	lock   sync.Mutex
+	t      *tunnel // 服务该proxy的tunnel
+	reason string  // 结束的原因，只记录第一个
+	err    string
+}
+
+func (this *proxy) Tunnel() *tunnel {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.t
+}
+
+// in生成的会话ID只用于日志和管理接口，限制长度和字符
+func validSession(id string) bool {
+	if id == "" || len(id) > maxSessionLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// 记录结束的原因，已经记录过则忽略
+func (this *proxy) setReason(reason, err string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.reason == "" {
+		this.reason = reason
+		this.err = err
+	}
+}
+
+// 带上原因结束proxy
+func (this *proxy) Terminate(reason, err string) {
+	this.setReason(reason, err)
+	this.Shutdown()
+}
+
+// 拒绝in的请求
+func (this *proxy) refuse(resp *msg.Response, reason, message string) {
+	this.setReason(reason, message)
+	sessionsFailed.Inc(this.topicLabel(), reason)
+	this.log.Warn("session refused", "reason", reason, "error", message)
+	resp.Message = message
+	resp.Reason = reason
+	msg.WriteMsg(this.cli, resp)
+}
+
+func (this *proxy) loop(c *ControlRegistry) {
+	if this.cli == nil || this.svr != nil {
+		panic("invalid proxy")
+	}
+	defer this.loopShutdown.Complete()
+	defer this.cli.Close()
+	defer this.Shutdown()
+
+	// 回复报文
+	initMsg := &msg.Response{
+		Magic:   this.m.Magic,
+		Request: "InRequest",
+		Message: "",
+	}
+
+	// 协商版本和特性
+	caps, err := utils.Negotiate(this.m.MinVersion, this.m.Version, this.m.Features)
+	if err != nil {
+		this.refuse(initMsg, reasonVersion, err.Error())
+		return
+	}
+	this.caps = caps
+	initMsg.Version = caps.Version
+	initMsg.Features = caps.Features
+
+	// 校验合法性，in只能请求具体的Topic
+	if err := utils.CheckTopic(this.m.Type, false); err != nil {
+		this.refuse(initMsg, reasonTopic, err.Error())
+		return
+	}
+
+	// 校验证书身份
+	if !this.r.s.Config().AllowIn(this.m.Type, utils.PeerIdentity(this.cli)) {
+		this.refuse(initMsg, reasonIdentity, fmt.Sprintf("identity [%s] can not access topic [%s]", utils.PeerIdentity(this.cli), this.m.Type))
+		return
+	}
+
+	// 校验签名
+	token := this.r.s.Config().TopicToken(this.m.Type)
+	if err := this.r.s.checkAuth(caps, []string{token}, this.m.Magic, this.m.Type, this.m.Timestamp, this.m.Sign); err != nil {
+		this.refuse(initMsg, reasonAuth, err.Error())
+		return
+	}
+
+	if this.m.Target != "" {
+		if err := utils.CheckTarget(this.m.Target); err != nil {
+			this.refuse(initMsg, reasonTarget, err.Error())
+			return
+		}
+	}
+
+	// 找到mng tunnel
+	t := c.Get(this.m.Type)
+	if t == nil {
+		this.refuse(initMsg, reasonNoTunnel, fmt.Sprintf("can not find tunnel [%s]", this.m.Type))
+		return
+	}
+	// 旧版本的out会忽略目标地址，直接连接它的后端
+	if this.m.Target != "" && !t.caps.Has(utils.FeatureTarget) {
+		this.refuse(initMsg, reasonTarget, fmt.Sprintf("out of topic [%s] does not support target", this.m.Type))
+		return
+	}
+	t.Add(this)
+	defer t.Del(this)
+	this.lock.Lock()
+	this.t = t
+	this.lock.Unlock()
+
+	// 获得空闲的连接
+	start := time.Now()
+	newConn, err := t.GetFreeTunnel()
+	waitTunnel := time.Since(start)
+	if err != nil || newConn == nil {
+		reason := reasonTunnelShutdown
+		if err == errTunnelTimeout {
+			reason = reasonTimeout
+		}
+		this.refuse(initMsg, reason, err.Error())
+		return
+	}
+	this.svr = newConn
+	defer this.svr.Close()
+
+	// 上游服务器发送请求，用于激活data传输
+	uniqKey := utils.Magic()
+	compress := t.Compress(this.caps, this.m.Compress)
+	msg.WriteMsgWith(newConn, t.codec, &msg.DataActiveRequest{
+		Magic:    uniqKey,
+		Type:     this.m.Type,
+		Compress: compress,
+		Session:  this.id,
+		Target:   this.m.Target,
+	})
+
+	// 等待响应
+	this.svr.SetReadDeadline(this.r.s.handshakeDeadline())
+	start = time.Now()
+	if resp, err := msg.ReadResponse(this.svr, uniqKey, "DataActiveRequest"); err != nil {
+		// out拒绝（后端连接失败）、超时或者连接断开
+		reason := reasonActivate
+		if resp != nil && resp.Reason == reasonTarget {
+			reason = reasonTarget
+		} else if resp != nil {
+			reason = reasonBackendDial
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			reason = reasonTimeout
+		}
+		this.refuse(initMsg, reason, err.Error())
+		return
+	}
+	activate := time.Since(start)
+	this.svr.SetReadDeadline(time.Time{})
+
+	// 压缩在in和out之间端到端进行，proxy只转发
+	initMsg.Compress = compress
+	msg.WriteMsg(this.cli, initMsg)
+	this.log.Info("start data exchange", "tunnel", t.id, "compress", compress,
+		"wait_tunnel", waitTunnel, "activate", activate, "handshake", time.Since(this.created))
+	sessionsStarted.Inc(this.topicLabel())
+
+	// 开始数据交换
+	first, err := utils.JoinCounted(this.cli, this.svr, this, &this.bytesOut, &this.bytesIn)
+	switch {
+	case first == this.cli && err == nil:
+		this.setReason(reasonClientEOF, "")
+	case first == this.cli:
+		this.setReason(reasonClientError, err.Error())
+	case err == nil:
+		this.setReason(reasonUpstreamEOF, "")
+	default:
+		this.setReason(reasonUpstreamError, err.Error())
+	}
+	sessionBytes.Add(float64(atomic.LoadInt64(&this.bytesIn)), this.topicLabel(), "in")
+	sessionBytes.Add(float64(atomic.LoadInt64(&this.bytesOut)), this.topicLabel(), "out")
+	this.log.Info("data exchange finished",
+		"bytes_in", atomic.LoadInt64(&this.bytesIn),
+		"bytes_out", atomic.LoadInt64(&this.bytesOut))
+}
+
+func (this *proxy) Shutdown() {
+	this.shutdown.Begin()
+}
+
+func (this *proxy) Run(c *ControlRegistry) {
+	defer this.shutdown.Complete()
+
+	this.r.Add(this)
+	defer this.r.Del(this)
+
+	go this.loop(c)
+	// 连接服务器
+	this.shutdown.WaitBegin()
+	this.log.Debug("start to shutdown session")
+
+	// 关闭socket
+	utils.CloseReadWrite(this.cli)
+	if this.svr != nil {
+		utils.CloseReadWrite(this.svr)
+	}
+
+	// 等待loop结束
+	this.loopShutdown.WaitComplete()
+	this.access()
+}
+
+// 写访问日志
+func (this *proxy) access() {
+	record := &logs.AccessRecord{
+		Start:    this.created,
+		End:      time.Now(),
+		Session:  this.id,
+		Topic:    this.m.Type,
+		Client:   this.cli.RemoteAddr().String(),
+		Identity: utils.PeerIdentity(this.cli),
+		BytesIn:  atomic.LoadInt64(&this.bytesIn),
+		BytesOut: atomic.LoadInt64(&this.bytesOut),
+		Target:   this.m.Target,
+	}
+	this.lock.Lock()
+	if this.t != nil {
+		record.Upstream = this.t.mng.RemoteAddr().String()
+		record.Tunnel = this.t.id
+	}
+	record.Reason = this.reason
+	record.Error = this.err
+	this.lock.Unlock()
+	this.r.s.access.Write(record)
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+type ProxyRegistry struct {
+	s       *Server
+	proxies map[*proxy]int
+	sync.Mutex
+}
+
+func NewProxyRegistry(s *Server) *ProxyRegistry {
+	return &ProxyRegistry{
+		s:       s,
+		proxies: make(map[*proxy]int),
+	}
+}
+
+func (this *ProxyRegistry) NewProxy(
+	cli net.Conn,
+	m *msg.InRequest,
+	c *ControlRegistry) {
+	p := &proxy{
+		r:            this,
+		cli:          cli,
+		m:            m,
+		shutdown:     utils.NewShutdown(true),
+		loopShutdown: utils.NewShutdown(false),
+		id:           m.Session,
+		created:      time.Now(),
+	}
+	// 旧版本的in不带会话ID
+	if !validSession(p.id) {
+		p.id = utils.RandId(8)
+	}
+	p.log = logs.With("session", p.id, "topic", m.Type, "remote", cli.RemoteAddr().String(), "magic", m.Magic)
+	if m.Target != "" {
+		p.log = p.log.With("target", m.Target)
+	}
+	go p.Run(c)
+}
+
+func (this *ProxyRegistry) Add(s *proxy) {
+	s.log.Debug("add session")
+	this.Lock()
+	defer this.Unlock()
+
+	if _, ok := this.proxies[s]; !ok {
+		this.proxies[s] = 0
+	} else {
+		panic("repeat add\n")
+	}
+}
+
+func (this *ProxyRegistry) Del(s *proxy) {
+	s.log.Debug("del session")
+	this.Lock()
+	defer this.Unlock()
+
+	if _, ok := this.proxies[s]; ok {
+		delete(this.proxies, s)
+	} else {
+		panic("empty del\n")
+	}
+}
+
+func (this *ProxyRegistry) Shutdown() {
+	logs.Info("start to shutdown ProxyRegistry")
+	this.Lock()
+	defer this.Unlock()
+
+	// 尝试关闭
+	for k, _ := range this.proxies {
+		k.Terminate(reasonShutdown, "")
+	}
+
+}
+
+func (this *ProxyRegistry) WaitComplele() {
+	for {
+		time.Sleep(time.Millisecond * 100)
+		this.Lock()
+
+		if len(this.proxies) == 0 {
+			this.Unlock()
+			break
+		}
+
+		this.Unlock()
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////
+func (this *Server) handshakeDeadline() time.Time {
+	return time.Now().Add(time.Duration(this.Config().HandshakeTimeout) * time.Millisecond)
+}
+
+// 拒绝握手请求并关闭连接
+func refuse(conn net.Conn, log *logs.Logger, resp *msg.Response, reason, message string) {
+	handshakeErrors.Inc(resp.Request, reason)
+	log.Warn("handshake refused", "request", resp.Request, "reason", reason, "error", message)
+	resp.Message = message
+	msg.WriteMsg(conn, resp)
+	conn.Close()
+}
+
+func (this *Server) handle(conn net.Conn) {
+	p, c := this.p, this.c
+	log := logs.With("remote", conn.RemoteAddr().String())
+
+	// 握手阶段限时，防止慢速的客户端占用go routine
+	conn.SetReadDeadline(this.handshakeDeadline())
+	m, tp, err := msg.ReadMsg(conn)
+	if err != nil {
+		handshakeErrors.Inc("", reasonRead)
+		log.Warn("read handshake failed", "error", err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	log.Debug("new request", "request", tp)
+	if tp == "OutRequest" {
+		req, ok := m.(*msg.OutRequest)
+		if !ok {
+			log.Warn("invalid OutRequest type")
+			return
+		}
+		log = log.With("topic", req.Type, "magic", req.Magic)
+
+		initMsg := &msg.Response{
+			Magic:   req.Magic,
+			Request: tp,
+			Message: "",
+		}
+
+		// 协商版本和特性
+		caps, err := utils.Negotiate(req.MinVersion, req.Version, req.Features)
+		if err != nil {
+			refuse(conn, log, initMsg, reasonVersion, err.Error())
+			return
+		}
+		initMsg.Version = caps.Version
+		initMsg.Features = caps.Features
+
+		// 校验合法性
+		if err := utils.CheckTopic(req.Type, true); err != nil {
+			refuse(conn, log, initMsg, reasonTopic, err.Error())
+			return
+		}
+
+		// 校验证书身份
+		if !this.Config().AllowOut(req.Type, utils.PeerIdentity(conn)) {
+			refuse(conn, log, initMsg, reasonIdentity, fmt.Sprintf("identity [%s] can not register topic [%s]", utils.PeerIdentity(conn), req.Type))
+			return
+		}
+
+		// 校验签名
+		if err := this.checkAuth(caps, this.Config().OutTokens(req.Type), req.Magic, req.Type, req.Timestamp, req.Sign); err != nil {
+			refuse(conn, log, initMsg, reasonAuth, err.Error())
+			return
+		}
+
+		// 注册，按照Topic的策略可能被拒绝
+		t, err := c.NewTunnel(conn, req, caps)
+		if err != nil {
+			refuse(conn, log, initMsg, reasonRefused, err.Error())
+			return
+		}
+
+		if caps.Has(utils.FeatureLease) {
+			initMsg.Lease = t.lease
+		}
+		if caps.Has(utils.FeatureCodec) {
+			initMsg.Codec = t.codec.Name()
+		}
+		msg.WriteMsg(conn, initMsg)
+		registrations.Inc(req.Type)
+		t.log.Info("tunnel registered", "version", caps.Version, "codec", t.codec.Name(), "standby", req.Standby)
+
+		go t.Run()
+	} else if tp == "OutDataRequest" {
+		req, ok := m.(*msg.OutDataRequest)
+		if !ok {
+			log.Warn("invalid OutDataRequest type")
+			return
+		}
+		log = log.With("topic", req.Type, "magic", req.Magic)
+
+		initMsg := &msg.Response{
+			Magic:   req.Magic,
+			Request: tp,
+			Message: "",
+		}
+
+		// 协商版本和特性
+		caps, err := utils.Negotiate(req.MinVersion, req.Version, req.Features)
+		if err != nil {
+			refuse(conn, log, initMsg, reasonVersion, err.Error())
+			return
+		}
+		initMsg.Version = caps.Version
+		initMsg.Features = caps.Features
+
+		// 校验证书身份
+		if !this.Config().AllowOut(req.Type, utils.PeerIdentity(conn)) {
+			refuse(conn, log, initMsg, reasonIdentity, fmt.Sprintf("identity [%s] can not register topic [%s]", utils.PeerIdentity(conn), req.Type))
+			return
+		}
+
+		// 校验签名
+		if err := this.checkAuth(caps, this.Config().OutTokens(req.Type), req.Magic, req.Type, req.Timestamp, req.Sign); err != nil {
+			refuse(conn, log, initMsg, reasonAuth, err.Error())
+			return
+		}
+
+		// 找到mng tunnel
+		lease := req.Lease
+		if !caps.Has(utils.FeatureLease) {
+			lease = ""
+		}
+		t := c.Find(req.Type, lease)
+		if t == nil {
+			refuse(conn, log, initMsg, reasonNoTunnel, fmt.Sprintf("can not find tunnel %s", req.Type))
+			return
+		}
+
+		// 注册空闲的数据tunnel
+		if err := t.RegisterDataConn(conn); err != nil {
+			refuse(conn, log, initMsg, reasonPoolFull, err.Error())
+			return
+		}
+
+		msg.WriteMsg(conn, initMsg)
+	} else if tp == "InRequest" {
+		req, ok := m.(*msg.InRequest)
+		if !ok {
+			log.Warn("invalid InRequest type")
+			return
+		}
+
+		p.NewProxy(conn, req, c)
+	} else if tp == "MuxRequest" {
+		req, ok := m.(*msg.MuxRequest)
+		if !ok {
+			log.Warn("invalid MuxRequest type")
+			return
+		}
+		log = log.With("magic", req.Magic)
+
+		initMsg := &msg.Response{
+			Magic:   req.Magic,
+			Request: tp,
+			Message: "",
+		}
+
+		// 协商版本和特性
+		caps, err := utils.Negotiate(req.MinVersion, req.Version, req.Features)
+		if err == nil && !caps.Has(utils.FeatureMux) {
+			err = fmt.Errorf("mux not supported")
+		}
+		if err != nil {
+			refuse(conn, log, initMsg, reasonVersion, err.Error())
+			return
+		}
+		initMsg.Version = caps.Version
+		initMsg.Features = caps.Features
+		msg.WriteMsg(conn, initMsg)
+
+		this.serveMux(conn)
+	} else {
+		log.Warn("invalid request", "request", tp)
+		handshakeErrors.Inc(unknownRequest, reasonInvalid) // 未知的请求类型不作为标签
+		msg.WriteMsg(conn, &msg.Response{
+			Magic:   "invalid tp",
+			Request: "invalid tp",
+			Message: fmt.Sprintf("invalid type %s", tp),
+		})
+		conn.Close()
+
+	}
+}
+
+// 多路复用连接上的每个stream都当作新的连接处理
+func (this *Server) serveMux(conn net.Conn) {
+	session := mux.Server(conn)
+	defer session.Close()
+	logs.Info("new mux session", "remote", conn.RemoteAddr().String())
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			logs.Info("mux session ended", "remote", conn.RemoteAddr().String())
+			break
+		}
+		go this.handle(stream)
+	}
+}
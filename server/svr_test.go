@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qjw/proxy/logs"
+	"github.com/qjw/proxy/msg"
+	"github.com/qjw/proxy/utils"
+)
+
+// 只用于注册表的tunnel，不带连接
+func testTunnel(topic, identity, lease string, standby bool) *tunnel {
+	return &tunnel{
+		m:        &msg.OutRequest{Type: topic, Lease: lease, Standby: standby},
+		identity: identity,
+		shutdown: utils.NewShutdown(true),
+		log:      logs.With("topic", topic),
+	}
+}
+
+func testRegistry(t *testing.T, topics ...*Topic) *ControlRegistry {
+	t.Helper()
+	conf := DefaultConfig()
+	conf.Topics = topics
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.c
+}
+
+func TestStandbyPolicy(t *testing.T) {
+	r := testRegistry(t,
+		&Topic{Name: "reject", Policy: PolicyReject},
+		&Topic{Name: "lease", Policy: PolicyLease},
+	)
+
+	for _, tp := range []string{"reject", "lease"} {
+		owner := testTunnel(tp, "owner", "", false)
+		if err := r.Add(owner); err != nil {
+			t.Fatalf("%s: register owner: %s", tp, err)
+		}
+		if err := r.Add(testTunnel(tp, "other", "", true)); err == nil {
+			t.Errorf("%s: standby of another out should be refused", tp)
+		}
+		// 主out掉线之后也不能通过备用抢占，lease策略下保留的租约过期之后才可以
+		r.Del(owner)
+		if tp == "lease" {
+			if err := r.Add(testTunnel(tp, "other", "", true)); err == nil {
+				t.Errorf("%s: standby should be refused while the lease is reserved", tp)
+			}
+			expireReservation(r, tp)
+		}
+		if err := r.Add(testTunnel(tp, "other", "", true)); err != nil {
+			t.Errorf("%s: standby on empty topic: %s", tp, err)
+		}
+		if got := r.Get(tp); got == nil || got.identity != "other" {
+			t.Errorf("%s: standby on empty topic should be promoted", tp)
+		}
+	}
+
+	// lease策略下相同证书身份的备用可以注册
+	standby := testTunnel("lease", "other", "", true)
+	if err := r.Add(standby); err != nil {
+		t.Fatalf("standby with same identity: %s", err)
+	}
+	// 备用的out可以用自己的租约顶替自己之前的注册
+	again := testTunnel("reject", "", "", true)
+	if err := r.Add(again); err == nil {
+		t.Fatal("anonymous standby should be refused")
+	}
+	r.Lock()
+	lease := r.tunnels["reject"].tunnels[0].lease
+	r.Unlock()
+	if err := r.Add(testTunnel("reject", "", lease, true)); err != nil {
+		t.Fatalf("standby with own lease: %s", err)
+	}
+}
+
+func TestStandbyReplacePolicy(t *testing.T) {
+	r := testRegistry(t)
+	if err := r.Add(testTunnel("db", "a", "", false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(testTunnel("db", "b", "", true)); err != nil {
+		t.Fatalf("standby under replace policy: %s", err)
+	}
+	if got := r.Get("db"); got == nil || got.identity != "a" {
+		t.Fatal("standby should not take traffic while primary is registered")
+	}
+}
+
+func expireReservation(r *ControlRegistry, tp string) {
+	r.Lock()
+	defer r.Unlock()
+	if v, ok := r.reserved[tp]; ok {
+		v.expire = time.Now().Add(-time.Second)
+	}
+}
+
+// lease策略下out掉线之后保留租约，重连间隙其他的out不能抢占
+func TestLeaseReservation(t *testing.T) {
+	r := testRegistry(t, &Topic{Name: "db", Policy: PolicyLease})
+
+	owner := testTunnel("db", "", "", false)
+	if err := r.Add(owner); err != nil {
+		t.Fatal(err)
+	}
+	r.Del(owner)
+	if err := r.Add(testTunnel("db", "other", "", false)); err == nil {
+		t.Fatal("other out should be refused while the lease is reserved")
+	}
+
+	// 原来的out带着租约重连，续用原来的租约
+	again := testTunnel("db", "", owner.lease, false)
+	if err := r.Add(again); err != nil {
+		t.Fatalf("owner reconnect: %s", err)
+	}
+	if again.lease != owner.lease {
+		t.Fatal("owner should keep its lease")
+	}
+
+	// 相同证书身份同样可以
+	r.Del(again)
+	identified := testTunnel("db", "owner", "", false)
+	r.Lock()
+	r.reserved["db"].identity = "owner"
+	r.Unlock()
+	if err := r.Add(identified); err != nil {
+		t.Fatalf("same identity: %s", err)
+	}
+	if identified.lease != owner.lease {
+		t.Fatal("same identity should keep the lease")
+	}
+
+	// 过期之后其他的out可以注册
+	r.Del(identified)
+	expireReservation(r, "db")
+	other := testTunnel("db", "other", "", false)
+	if err := r.Add(other); err != nil {
+		t.Fatalf("reservation expired: %s", err)
+	}
+	if other.lease == owner.lease {
+		t.Fatal("new owner should get a new lease")
+	}
+}
+
+// 不支持lease特性的out按照旧版本的方式注册，忽略租约、备用和权重
+func TestTunnelWithoutLeaseFeature(t *testing.T) {
+	r := testRegistry(t)
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	caps := &utils.Capabilities{Version: utils.Version, Features: []string{utils.FeatureAuth}}
+	m := &msg.OutRequest{Type: "db", Lease: "old", Standby: true, Weight: 5}
+	tn, err := r.NewTunnel(a, m, caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.running.Done()
+	if tn.m.Standby || tn.m.Lease != "" || tn.Weight() != 1 {
+		t.Fatalf("lease fields should be ignored: %+v", tn.m)
+	}
+	if got := r.Get("db"); got != tn {
+		t.Fatal("tunnel should serve directly instead of standing by")
+	}
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 握手签名，HMAC-SHA256(token, magic|type|timestamp)
+func Sign(token, magic, tp string, ts int64) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s|%s|%d", magic, tp, ts)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 校验握手签名，token为空表示不需要认证
+func CheckSign(token, magic, tp string, ts int64, sign string) error {
+	if token == "" {
+		return nil
+	}
+	if sign == "" {
+		return fmt.Errorf("authentication required")
+	}
+
+	delta := time.Since(time.Unix(ts, 0))
+	if delta < 0 {
+		delta = -delta
+	}
+	if delta > time.Duration(AuthWindow)*time.Millisecond {
+		return fmt.Errorf("authentication expired")
+	}
+
+	expect := Sign(token, magic, tp, ts)
+	if !hmac.Equal([]byte(expect), []byte(sign)) {
+		return fmt.Errorf("authentication failed")
+	}
+	return nil
+}
+
+// 记录有效期内已经用过的magic，防止握手报文被重放
+type ReplayCache struct {
+	sync.Mutex
+	seen  map[string]time.Time
+	queue []replayEntry // 按照记录的时间排序，过期的从头部删除
+}
+
+type replayEntry struct {
+	magic string
+	at    time.Time
+}
+
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{
+		seen: make(map[string]time.Time),
+	}
+}
+
+// magic第一次出现返回true
+func (this *ReplayCache) Check(magic string) bool {
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+	this.expire(now)
+
+	if _, ok := this.seen[magic]; ok {
+		return false
+	}
+	this.seen[magic] = now
+	this.queue = append(this.queue, replayEntry{magic: magic, at: now})
+	return true
+}
+
+// 删除超过两倍时间窗口的记录，只检查队列头部，均摊O(1)
+func (this *ReplayCache) expire(now time.Time) {
+	retention := 2 * time.Duration(AuthWindow) * time.Millisecond
+	n := 0
+	for n < len(this.queue) && now.Sub(this.queue[n].at) > retention {
+		delete(this.seen, this.queue[n].magic)
+		this.queue[n] = replayEntry{}
+		n++
+	}
+	// append扩容时只复制剩下的记录，头部占用的内存随之释放
+	this.queue = this.queue[n:]
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCheckSign(t *testing.T) {
+	now := time.Now().Unix()
+	sign := Sign("secret", "magic", "db", now)
+	cases := []struct {
+		name  string
+		token string
+		tp    string
+		ts    int64
+		sign  string
+		ok    bool
+	}{
+		{"no token", "", "db", now, "", true},
+		{"valid", "secret", "db", now, sign, true},
+		{"missing", "secret", "db", now, "", false},
+		{"wrong token", "other", "db", now, sign, false},
+		{"wrong topic", "secret", "web", now, sign, false},
+		{"expired", "secret", "db", now - int64(AuthWindow/1000) - 10, Sign("secret", "magic", "db", now-int64(AuthWindow/1000)-10), false},
+	}
+	for _, c := range cases {
+		if err := CheckSign(c.token, "magic", c.tp, c.ts, c.sign); (err == nil) != c.ok {
+			t.Errorf("%s: CheckSign = %v, expect ok %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	c := NewReplayCache()
+	if !c.Check("a") || !c.Check("b") {
+		t.Fatal("first use should pass")
+	}
+	if c.Check("a") {
+		t.Fatal("replay should be refused")
+	}
+
+	// 过期的记录从队列头部删除
+	old := time.Now().Add(-3 * time.Duration(AuthWindow) * time.Millisecond)
+	c.queue[0].at = old
+	c.seen["a"] = old
+	if !c.Check("c") {
+		t.Fatal("first use should pass")
+	}
+	if _, ok := c.seen["a"]; ok || len(c.queue) != 2 {
+		t.Fatalf("expired magic not removed, %d left", len(c.queue))
+	}
+	if !c.Check("a") {
+		t.Fatal("expired magic can be used again")
+	}
+}
+
+func BenchmarkReplayCache(b *testing.B) {
+	c := NewReplayCache()
+	for i := 0; i < 100000; i++ {
+		c.Check(fmt.Sprintf("warm-%d", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Check(fmt.Sprintf("magic-%d", i))
+	}
+}
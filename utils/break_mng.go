@@ -7,7 +7,8 @@ import (
 )
 
 type breakMng struct {
-	done chan bool
+	done   chan bool
+	reload func() // 收到SIGHUP时调用，为空则不处理SIGHUP
 }
 
 func NewExitManager() *breakMng {
@@ -25,17 +26,31 @@ func (this breakMng) Wait() {
 	<-this.done
 }
 
+// 设置重新加载配置的回调，必须在Run之前调用
+func (this *breakMng) OnReload(reload func()) {
+	this.reload = reload
+}
+
 func (this *breakMng) Run(handle func()) {
 	// 信号
 	sigs := make(chan os.Signal, 1)
 	defer close(sigs)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	if this.reload != nil {
+		signal.Notify(sigs, syscall.SIGHUP)
+	}
+	defer signal.Stop(sigs)
 
 	this.done = make(chan bool)
 	defer func() {
 		this.done <- true
 	}()
 
-	<-sigs
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		this.reload()
+	}
 	handle()
 }
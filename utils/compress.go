@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	CompressDeflate string = "deflate"
+)
+
+// 支持的压缩算法，按优先级排列
+var CompressMethods = []string{
+	CompressDeflate,
+}
+
+func SupportCompress(method string) bool {
+	for _, v := range CompressMethods {
+		if v == method {
+			return true
+		}
+	}
+	return false
+}
+
+// 压缩的数据连接，每次Write都会Flush，保证交互式的协议（ssh）不会被缓冲
+type compressConn struct {
+	net.Conn
+	r    io.ReadCloser
+	lock sync.Mutex // 关闭时可能有其他go routine正在Write
+	w    *flate.Writer
+}
+
+func (this *compressConn) Read(b []byte) (int, error) {
+	return this.r.Read(b)
+}
+
+func (this *compressConn) Write(b []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	n, err := this.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, this.w.Flush()
+}
+
+// 先写入压缩流的结束块，对端才能读到正常的EOF，否则是unexpected EOF
+func (this *compressConn) CloseWrite() error {
+	this.lock.Lock()
+	err := this.w.Close()
+	this.lock.Unlock()
+	if err2 := closeWrite(this.Conn); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (this *compressConn) CloseRead() error {
+	return closeRead(this.Conn)
+}
+
+func (this *compressConn) Close() error {
+	this.lock.Lock()
+	this.w.Close()
+	this.lock.Unlock()
+	this.r.Close()
+	return this.Conn.Close()
+}
+
+// 按照协商的算法包装数据连接，method为空时原样返回
+func Compress(c net.Conn, method string) (net.Conn, error) {
+	switch method {
+	case "":
+		return c, nil
+	case CompressDeflate:
+		w, err := flate.NewWriter(c, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &compressConn{
+			Conn: c,
+			r:    flate.NewReader(c),
+			w:    w,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compress method [%s]", method)
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 两端都是TCP连接，才能测试半关闭
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	return c1, c2
+}
+
+// 返回两端压缩的连接，以及z1底层的连接
+func compressPair(t *testing.T) (net.Conn, net.Conn, net.Conn) {
+	t.Helper()
+	c1, c2 := tcpPair(t)
+	z1, err := Compress(c1, CompressDeflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z2, err := Compress(c2, CompressDeflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z1, z2, c1
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	z1, z2, _ := compressPair(t)
+	data := bytes.Repeat([]byte("compress me "), 10000)
+	go func() {
+		// 多次写入，每次都要能被对端立即读到
+		for i := 0; i < len(data); i += 1000 {
+			z1.Write(data[i : i+1000])
+		}
+		z1.(closeWriter).CloseWrite()
+	}()
+	got, err := io.ReadAll(z2)
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes, expect %d", len(got), len(data))
+	}
+}
+
+func TestCompressUnsupported(t *testing.T) {
+	c1, _ := tcpPair(t)
+	if c, err := Compress(c1, ""); err != nil || c != c1 {
+		t.Fatal("empty method should return the conn as is")
+	}
+	if _, err := Compress(c1, "gzip"); err == nil {
+		t.Fatal("unsupported method should fail")
+	}
+}
+
+type closeBoth struct {
+	once sync.Once
+	a, b net.Conn
+}
+
+func (this *closeBoth) Shutdown() {
+	this.once.Do(func() {
+		CloseReadWrite(this.a)
+		CloseReadWrite(this.b)
+	})
+}
+
+// 本地连接正常关闭时，对端读到的是正常的EOF，两边结束的原因都是nil；
+// 和in/out一样，结束时只关闭底层的连接
+func TestCompressJoinClean(t *testing.T) {
+	local, cli := tcpPair(t)
+	z1, z2, raw := compressPair(t)
+
+	type result struct {
+		first net.Conn
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var from, to int64
+		first, err := JoinCounted(cli, z1, &closeBoth{a: cli, b: raw}, &from, &to)
+		done <- result{first, err}
+	}()
+
+	data := []byte("last words before close")
+	local.Write(data)
+	local.(closeWriter).CloseWrite()
+
+	z2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(z2)
+	if err != nil {
+		t.Fatalf("peer read failed: %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %q, expect %q", got, data)
+	}
+	r := <-done
+	if r.first != cli || r.err != nil {
+		t.Fatalf("join ended by %v with %v, expect clean close of the local conn", r.first, r.err)
+	}
+}
@@ -2,11 +2,18 @@ package utils
 
 const (
 	DftType           string = "default" // 默认的Type
-	Version           string = "0.0.1"   // 版本号
+	Version           string = "0.1.0"   // 版本号，也是支持的最高协议版本
+	MinVersion        string = "0.0.1"   // 支持的最低协议版本
 	FreeTunnelTimeout int    = 5000      // 获取空闲tunnel的超时(毫秒）
 	TunnelBufLen      int    = 100       // 空闲tunnel 缓冲区长度
 	MagicLen          int    = 16        // magic的字符串长度
 	RetryInterval     int    = 2000      // 重连间隔(毫秒）
 	HeartbeatInterval int    = 2000      // 心跳检测间隔(毫秒）
 	HeartbeatTimeout  int    = 20 * 1000 // 心跳超时(毫秒）
+	AuthWindow        int    = 300000    // 握手签名的有效时间窗口(毫秒）
+	MaxRetryBackoff   int    = 5         // 注册被拒绝时重连间隔最多翻倍的次数
+	MaxFrameSize      int64  = 64 * 1024 // 单个报文的最大长度(字节）
+	HandshakeTimeout  int    = 10 * 1000 // 握手报文的读超时(毫秒）
+	UnhealthyInterval int    = 30 * 1000 // 连接proxy失败之后标记为不可用的时间(毫秒）
+	LeaseTimeout      int    = 60 * 1000 // lease策略下out掉线之后保留租约的时间(毫秒）
 )
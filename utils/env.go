@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 替换配置文件中的${NAME}和${NAME:-default}，$$表示$；引用未定义并且没有默认值的变量时报错
+func Interpolate(text string) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '$' || i+1 == len(text) {
+			buf.WriteByte(c)
+			continue
+		}
+		switch text[i+1] {
+		case '$':
+			buf.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(text[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ at offset %d", i)
+			}
+			expr := text[i+2 : i+2+end]
+			name, def, hasDef := strings.Cut(expr, ":-")
+			if name == "" {
+				return "", fmt.Errorf("empty variable name at offset %d", i)
+			}
+			value, ok := os.LookupEnv(name)
+			if !ok || value == "" && hasDef {
+				if !hasDef {
+					return "", fmt.Errorf("undefined environment variable %s", name)
+				}
+				value = def
+			}
+			buf.WriteString(value)
+			i += 2 + end
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), nil
+}
+
+// 用环境变量覆盖配置中的标量字段，变量名为前缀加上大写的JSON路径，用_连接，
+// 例如PROXY_PORT、OUT_HEARTBEAT_TIMEOUT、PROXY_LOG_LEVEL、IN_NETWORKS_0_TOKEN；
+// 字符串数组用逗号分隔；数组只能覆盖已有的元素
+func EnvOverride(prefix string, obj interface{}) error {
+	v := NewValidator()
+	envOverride(v, prefix, reflect.ValueOf(obj))
+	return v.Err()
+}
+
+func envOverride(v *Validator, name string, value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			// 只有设置了下级的环境变量才创建
+			if !hasEnv(name) || !value.CanSet() {
+				return
+			}
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		envOverride(v, name, value.Elem())
+	case reflect.Struct:
+		t := value.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			tag := strings.Split(f.Tag.Get("json"), ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if tag == "" {
+				tag = f.Name
+			}
+			envOverride(v, name+"_"+strings.ToUpper(tag), value.Field(i))
+		}
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.String {
+			if s, ok := os.LookupEnv(name); ok {
+				list := make([]string, 0)
+				for _, item := range strings.Split(s, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						list = append(list, item)
+					}
+				}
+				value.Set(reflect.ValueOf(list))
+			}
+			return
+		}
+		for i := 0; i < value.Len(); i++ {
+			envOverride(v, fmt.Sprintf("%s_%d", name, i), value.Index(i))
+		}
+	default:
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := setScalar(value, s); err != nil {
+			v.Errorf(name, "invalid value [%s]: %s", s, err.Error())
+		}
+	}
+}
+
+func hasEnv(name string) bool {
+	if _, ok := os.LookupEnv(name); ok {
+		return true
+	}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, name+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+func setScalar(value reflect.Value, s string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expect bool")
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expect %s", value.Type())
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expect %s", value.Type())
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expect %s", value.Type())
+		}
+		value.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
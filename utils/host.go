@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net"
+	"strings"
+)
+
+// 按照主机匹配目标地址，pattern可以是域名、*.后缀（只匹配子域名）、IP或者CIDR；
+// 域名不区分大小写，CIDR和IP只匹配IP形式的host
+func MatchHost(pattern, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	ip := net.ParseIP(host)
+	if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+		return ip != nil && cidr.Contains(ip)
+	}
+	if p := net.ParseIP(pattern); p != nil {
+		return ip != nil && p.Equal(ip)
+	}
+
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// 主机匹配hosts中的任意一个并且端口在ports中，hosts或者ports为空匹配所有
+func MatchAddr(hosts []string, ports []uint16, host string, port uint16) bool {
+	if len(ports) > 0 {
+		found := false
+		for _, v := range ports {
+			if v == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(hosts) == 0 {
+		return true
+	}
+	for _, v := range hosts {
+		if MatchHost(v, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchHost的pattern是否合法
+func ValidHostPattern(pattern string) bool {
+	if _, _, err := net.ParseCIDR(pattern); err == nil {
+		return true
+	}
+	return ValidHost(strings.TrimPrefix(pattern, "*."))
+}
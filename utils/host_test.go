@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestMatchHost(t *testing.T) {
+	cases := []struct {
+		pattern string
+		host    string
+		expect  bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "a.example.com", false},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "11.1.2.3", false},
+		{"10.0.0.0/8", "10.example.com", false},
+		{"::1", "0:0::1", true},
+		{"127.0.0.1", "localhost", false},
+	}
+	for _, c := range cases {
+		if got := MatchHost(c.pattern, c.host); got != c.expect {
+			t.Errorf("MatchHost(%s, %s) = %v, expect %v", c.pattern, c.host, got, c.expect)
+		}
+	}
+}
+
+func TestMatchAddr(t *testing.T) {
+	cases := []struct {
+		hosts  []string
+		ports  []uint16
+		host   string
+		port   uint16
+		expect bool
+	}{
+		{nil, nil, "any", 1, true},
+		{nil, []uint16{443}, "any", 443, true},
+		{nil, []uint16{443}, "any", 80, false},
+		{[]string{"a.com", "*.b.com"}, nil, "x.b.com", 80, true},
+		{[]string{"a.com", "*.b.com"}, []uint16{80}, "a.com", 443, false},
+		{[]string{"a.com"}, []uint16{80}, "c.com", 80, false},
+	}
+	for _, c := range cases {
+		if got := MatchAddr(c.hosts, c.ports, c.host, c.port); got != c.expect {
+			t.Errorf("MatchAddr(%v, %v, %s, %d) = %v, expect %v", c.hosts, c.ports, c.host, c.port, got, c.expect)
+		}
+	}
+}
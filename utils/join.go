@@ -1,39 +1,100 @@
 package utils
 
 import (
-	"fmt"
+	"crypto/tls"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+
+	"github.com/qjw/proxy/logs"
 )
 
 type Shutdowner interface {
 	Shutdown()
 }
 
-func Join(c *net.TCPConn, c2 *net.TCPConn, s Shutdowner) (int64, int64) {
+type closeReader interface {
+	CloseRead() error
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// 关闭socket的读写两端（不释放），用于打断阻塞在上面的go routine
+func CloseReadWrite(c net.Conn) {
+	closeRead(c)
+	closeWrite(c)
+}
+
+func closeRead(c net.Conn) error {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if r, ok := c.(closeReader); ok {
+		return r.CloseRead()
+	}
+	return nil
+}
+
+func closeWrite(c net.Conn) error {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if w, ok := c.(closeWriter); ok {
+		return w.CloseWrite()
+	}
+	return nil
+}
+
+// 实时统计拷贝的字节数
+type countWriter struct {
+	w     io.Writer
+	count *int64
+}
+
+func (this countWriter) Write(b []byte) (int, error) {
+	n, err := this.w.Write(b)
+	atomic.AddInt64(this.count, int64(n))
+	return n, err
+}
+
+func Join(c net.Conn, c2 net.Conn, s Shutdowner) (int64, int64) {
+	var fromBytes, toBytes int64
+	JoinCounted(c, c2, s, &fromBytes, &toBytes)
+	return fromBytes, toBytes
+}
+
+// 和Join相同，拷贝过程中实时（原子）更新c2->c的字节数fromBytes和c->c2的字节数toBytes
+//
+// 返回最先结束读取的连接以及读写的错误（对端正常关闭时为nil），用于判断连接结束的原因
+func JoinCounted(c net.Conn, c2 net.Conn, s Shutdowner, fromBytes, toBytes *int64) (net.Conn, error) {
 	var wait sync.WaitGroup
+	var once sync.Once
+	var first net.Conn
+	var firstErr error
 
-	pipe := func(to *net.TCPConn, from *net.TCPConn, bytesCopied *int64) {
+	pipe := func(to net.Conn, from net.Conn, bytesCopied *int64) {
 		defer s.Shutdown()
 		defer wait.Done()
 
-		var err error
-		*bytesCopied, err = io.Copy(to, from)
+		_, err := io.Copy(countWriter{to, bytesCopied}, from)
 		if err != nil {
-			fmt.Printf("Copied %d bytes before failing with error [%s]\n",
-				*bytesCopied,
-				err.Error())
+			logs.Debug("copy failed", "bytes", atomic.LoadInt64(bytesCopied), "error", err)
 		} else {
-			// fmt.Printf("Copied %d bytes\n", *bytesCopied)
+			// 正常结束时先半关闭，压缩等包装的连接在这里写完结束块，对端读到正常的EOF
+			closeWrite(to)
 		}
+		once.Do(func() {
+			first, firstErr = from, err
+		})
 		return
 	}
 
 	wait.Add(2)
-	var fromBytes, toBytes int64
-	go pipe(c, c2, &fromBytes)
-	go pipe(c2, c, &toBytes)
+	go pipe(c, c2, fromBytes)
+	go pipe(c2, c, toBytes)
 	wait.Wait()
-	return fromBytes, toBytes
+	return first, firstErr
 }
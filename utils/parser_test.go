@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type tree = map[string]interface{}
+type list = []interface{}
+
+func TestParseYAML(t *testing.T) {
+	cases := []struct {
+		name   string
+		text   string
+		expect interface{}
+	}{
+		{"empty", "# only comment\n---\n", tree{}},
+		{"scalars", "a: 1\nb: -2.5\nc: true\nd: null\ne: ~\nf: text # comment\ng: \"q#x\"\nh: 'it''s'\ni: 1e3\nj: 0x10",
+			tree{"a": int64(1), "b": -2.5, "c": true, "d": nil, "e": nil, "f": "text", "g": "q#x", "h": "it's", "i": 1000.0, "j": "0x10"}},
+		{"nested", "log:\n  level: debug\n  output:\n    file: a.log\nport: 1",
+			tree{"log": tree{"level": "debug", "output": tree{"file": "a.log"}}, "port": int64(1)}},
+		{"sequence", "hosts:\n  - a\n  - b\n", tree{"hosts": list{"a", "b"}}},
+		{"sequence aligned", "hosts:\n- a\n- b\nport: 1\n", tree{"hosts": list{"a", "b"}, "port": int64(1)}},
+		{"sequence of maps", "networks:\n  - topic: a\n    port: 1\n  - topic: b\n",
+			tree{"networks": list{tree{"topic": "a", "port": int64(1)}, tree{"topic": "b"}}}},
+		{"nested item", "a:\n  -\n    b: 1\n", tree{"a": list{tree{"b": int64(1)}}}},
+		{"flow", "a: [1, \"x, y\", [2]]\nb: {k: v, n: 1}\nc: []",
+			tree{"a": list{int64(1), "x, y", list{int64(2)}}, "b": tree{"k": "v", "n": int64(1)}, "c": list{}}},
+		{"url value", "server: http://a:80/x", tree{"server": "http://a:80/x"}},
+		{"quoted key", "\"a b\": 1", tree{"a b": int64(1)}},
+		{"crlf", "a: 1\r\nb: 2\r\n", tree{"a": int64(1), "b": int64(2)}},
+		{"empty value", "a:\nb: 1", tree{"a": nil, "b": int64(1)}},
+	}
+	for _, c := range cases {
+		got, err := ParseYAML(c.text)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%s:\n got %#v\nwant %#v", c.name, got, c.expect)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	cases := []struct {
+		text string
+		err  string
+	}{
+		{"a: 1\na: 2", "line 2: duplicate key"},
+		{"a: 1\n  b: 2", "line 2: unexpected indentation"},
+		{"a:\n\t- 1", "line 2: tab"},
+		{"a: |\n  text", "unsupported syntax"},
+		{"a: &x 1", "unsupported syntax"},
+		{"a: \"open", "unterminated string"},
+		{"a: [1, 2", "unterminated flow sequence"},
+		{"a: {k v}", "expect key: value"},
+		{"just text", "line 1: expect key: value"},
+		{"- a\n  - b", "unexpected indentation"},
+	}
+	for _, c := range cases {
+		_, err := ParseYAML(c.text)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("ParseYAML(%q) = %v, expect %s", c.text, err, c.err)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	cases := []struct {
+		name   string
+		text   string
+		expect interface{}
+	}{
+		{"empty", "# comment\n\n", tree{}},
+		{"scalars", "a = 1\nb = -2.5\nc = true\nd = \"x\\ty\\u00e9\"\ne = 'C:\\path'\nf = 1_000\ng = 0x1f\nh = 1979-05-27 07:32:00 # comment",
+			tree{"a": int64(1), "b": -2.5, "c": true, "d": "x\ty\u00e9", "e": `C:\path`, "f": int64(1000), "g": int64(31), "h": "1979-05-27 07:32:00"}},
+		{"dotted", "a.b = 1\na.c = \"x\"\n\"d.e\" = 2", tree{"a": tree{"b": int64(1), "c": "x"}, "d.e": int64(2)}},
+		{"tables", "[log]\nlevel = \"debug\"\n[log.output]\nfile = \"a\"\n[admin]\naddr = \"x\"",
+			tree{"log": tree{"level": "debug", "output": tree{"file": "a"}}, "admin": tree{"addr": "x"}}},
+		{"array of tables", "[[networks]]\ntopic = \"a\"\n[networks.tls]\ncert = \"c\"\n[[networks]]\ntopic = \"b\"",
+			tree{"networks": list{tree{"topic": "a", "tls": tree{"cert": "c"}}, tree{"topic": "b"}}}},
+		{"arrays", "a = [1, 2,\n  3, # comment\n]\nb = [[\"x\"], []]\nc = { k = \"v\", n.m = 1 }",
+			tree{"a": list{int64(1), int64(2), int64(3)}, "b": list{list{"x"}, list{}}, "c": tree{"k": "v", "n": tree{"m": int64(1)}}}},
+		{"multiline", "a = \"\"\"\nline1\nline2 \\\n  cont\"\"\"\nb = '''\nraw\\n'''",
+			tree{"a": "line1\nline2 cont", "b": "raw\\n"}},
+	}
+	for _, c := range cases {
+		got, err := ParseTOML(c.text)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%s:\n got %#v\nwant %#v", c.name, got, c.expect)
+		}
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	cases := []struct {
+		text string
+		err  string
+	}{
+		{"a = 1\na = 2", "line 2: duplicate key"},
+		{"a = 1 b = 2", "unexpected"},
+		{"a = \"open", "unterminated string"},
+		{"a = \"\\q\"", "invalid escape"},
+		{"a = \"\\u12\"", "invalid unicode escape"},
+		{"a = inf", "unsupported value"},
+		{"a = abc", "invalid value"},
+		{"a = [1, 2", "expect , or ]"},
+		{"a = { k = 1", "expect , or }"},
+		{"[a\nb = 1", "expect ]"},
+		{"a = 1\n[[a]]", "not an array of tables"},
+		{"a = 1\n[a.b]", "is not a table"},
+		{"= 1", "expect key"},
+		{"a 1", "expect = after key"},
+		{"a =", "missing value"},
+	}
+	for _, c := range cases {
+		_, err := ParseTOML(c.text)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("ParseTOML(%q) = %v, expect %s", c.text, err, c.err)
+		}
+	}
+}
+
+type envNetwork struct {
+	Token  string   `json:"token"`
+	Port   uint16   `json:"port"`
+	Codecs []string `json:"codecs"`
+}
+
+type envConf struct {
+	Level    string        `json:"log_level"`
+	Timeout  int           `json:"timeout"`
+	Ratio    float64       `json:"ratio"`
+	Debug    bool          `json:"debug"`
+	Skip     string        `json:"-"`
+	Networks []*envNetwork `json:"networks"`
+	TLS      *struct {
+		Cert string `json:"cert"`
+	} `json:"tls"`
+	unexported string
+}
+
+func TestEnvOverride(t *testing.T) {
+	t.Setenv("TEST_LOG_LEVEL", "debug")
+	t.Setenv("TEST_TIMEOUT", "3000")
+	t.Setenv("TEST_RATIO", "0.5")
+	t.Setenv("TEST_DEBUG", "true")
+	t.Setenv("TEST_NETWORKS_0_TOKEN", "secret")
+	t.Setenv("TEST_NETWORKS_0_CODECS", "binary, json,,")
+	t.Setenv("TEST_NETWORKS_1_TOKEN", "ignored")
+	t.Setenv("TEST_TLS_CERT", "a.pem")
+
+	conf := &envConf{Networks: []*envNetwork{{Port: 1}}}
+	if err := EnvOverride("TEST", conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Level != "debug" || conf.Timeout != 3000 || conf.Ratio != 0.5 || !conf.Debug {
+		t.Errorf("scalars not overridden: %+v", conf)
+	}
+	n := conf.Networks[0]
+	if len(conf.Networks) != 1 || n.Token != "secret" || n.Port != 1 || !reflect.DeepEqual(n.Codecs, []string{"binary", "json"}) {
+		t.Errorf("networks not overridden: %+v", n)
+	}
+	if conf.TLS == nil || conf.TLS.Cert != "a.pem" {
+		t.Errorf("nil pointer not created: %+v", conf.TLS)
+	}
+
+	t.Setenv("TEST_TIMEOUT", "abc")
+	t.Setenv("TEST_NETWORKS_0_PORT", "70000")
+	err := EnvOverride("TEST", &envConf{Networks: []*envNetwork{{}}})
+	if err == nil || !strings.Contains(err.Error(), "TEST_TIMEOUT") || !strings.Contains(err.Error(), "TEST_NETWORKS_0_PORT") {
+		t.Fatalf("expect errors of both variables, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// 证书配置，三个程序共用
+type TLSConfig struct {
+	CA         string `json:"ca"`          // CA证书，用于校验对端证书
+	Cert       string `json:"cert"`        // 本端证书
+	Key        string `json:"key"`         // 本端私钥
+	ServerName string `json:"server_name"` // 客户端校验服务器证书时使用的域名，默认为server_host
+}
+
+func loadCA(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("invalid ca file [%s]", path)
+	}
+	return pool, nil
+}
+
+// 服务器使用，配置了CA则要求对端提供合法的客户端证书
+func ServerTLS(conf *TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.CA != "" {
+		if c.ClientCAs, err = loadCA(conf.CA); err != nil {
+			return nil, err
+		}
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// 客户端使用，配置了证书则向服务器提供客户端证书
+func ClientTLS(conf *TLSConfig, host string) (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if conf.ServerName != "" {
+		c.ServerName = conf.ServerName
+	}
+
+	var err error
+	if conf.CA != "" {
+		if c.RootCAs, err = loadCA(conf.CA); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Cert != "" || conf.Key != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// 连接服务器，conf为空则使用明文
+func Dial(host string, port uint16, conf *TLSConfig) (net.Conn, error) {
+	addr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	// 超时包括TLS握手，服务器不可达时尽快失败，便于切换到下一个
+	dialer := &net.Dialer{Timeout: time.Duration(HandshakeTimeout) * time.Millisecond}
+	if conf == nil {
+		return dialer.Dial("tcp", addr)
+	}
+
+	c, err := ClientTLS(conf, host)
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, c)
+}
+
+type netConner interface {
+	NetConn() net.Conn
+}
+
+// 对端证书的身份（CommonName），明文连接或者没有客户端证书时返回空
+func PeerIdentity(c net.Conn) string {
+	// 多路复用的逻辑连接使用承载连接的证书
+	for {
+		if _, ok := c.(*tls.Conn); ok {
+			break
+		}
+		nc, ok := c.(netConner)
+		if !ok {
+			return ""
+		}
+		c = nc.NetConn()
+	}
+
+	tc := c.(*tls.Conn)
+
+	state := tc.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.CommonName
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func writePEM(t *testing.T, path, tp string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: tp, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 在临时目录生成CA证书ca.pem
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{dir: t.TempDir(), cert: cert, key: key}
+	writePEM(t, filepath.Join(ca.dir, "ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+// 签发CommonName为name的证书，同时用于服务器(127.0.0.1)和客户端
+func (this *testCA) issue(t *testing.T, name string) *TLSConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, this.cert, &key.PublicKey, this.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &TLSConfig{
+		CA:   filepath.Join(this.dir, "ca.pem"),
+		Cert: filepath.Join(this.dir, name+".pem"),
+		Key:  filepath.Join(this.dir, name+".key"),
+	}
+	writePEM(t, conf.Cert, "CERTIFICATE", der)
+	writePEM(t, conf.Key, "EC PRIVATE KEY", keyDer)
+	return conf
+}
+
+// 握手之后返回服务器看到的对端身份
+func handshakeIdentity(t *testing.T, server, client *TLSConfig) (string, error) {
+	t.Helper()
+	sc, err := ServerTLS(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	identity := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			identity <- ""
+			return
+		}
+		defer c.Close()
+		if err := c.(*tls.Conn).Handshake(); err != nil {
+			identity <- ""
+			return
+		}
+		identity <- PeerIdentity(c)
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	c, err := Dial("127.0.0.1", uint16(addr.Port), client)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	// TLS1.3下客户端证书的错误在第一次读的时候才返回
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != nil && !isTimeout(err) && err != io.EOF {
+		return "", err
+	}
+	return <-identity, nil
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestPeerIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	server := ca.issue(t, "proxy")
+
+	id, err := handshakeIdentity(t, server, ca.issue(t, "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "app" {
+		t.Fatalf("identity = %q, expect app", id)
+	}
+
+	// 服务器配置了CA时必须提供客户端证书
+	if _, err := handshakeIdentity(t, server, &TLSConfig{CA: server.CA}); err == nil {
+		t.Fatal("client without certificate should be rejected")
+	}
+
+	// 其他CA签发的证书不被接受
+	other := newTestCA(t).issue(t, "app")
+	other.CA = server.CA
+	if _, err := handshakeIdentity(t, server, other); err == nil {
+		t.Fatal("certificate of unknown ca should be rejected")
+	}
+
+	// 明文连接没有身份
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if id := PeerIdentity(a); id != "" {
+		t.Fatalf("plain conn identity = %q", id)
+	}
+}
@@ -0,0 +1,453 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TOML的一个子集，足够写配置文件：key = value、点分隔的key、[table]、[[array]]、
+// 基本/字面字符串（含三引号多行）、整数、浮点、布尔、数组（可以跨行）、单行的内联表以及#注释；
+// 日期时间按照字符串处理
+type tomlParser struct {
+	text string
+	pos  int
+	line int
+	root map[string]interface{}
+}
+
+// 解析为map[string]interface{}、[]interface{}以及标量组成的树
+func ParseTOML(text string) (interface{}, error) {
+	p := &tomlParser{text: text, line: 1, root: make(map[string]interface{})}
+	current := p.root
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return p.root, nil
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(p.text[p.pos:], "[["):
+			p.pos += 2
+			current, err = p.arrayTable()
+		case p.peek() == '[':
+			p.pos++
+			current, err = p.table()
+		default:
+			err = p.keyValue(current)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (this *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("toml line %d: %s", this.line, fmt.Sprintf(format, args...))
+}
+
+func (this *tomlParser) eof() bool {
+	return this.pos >= len(this.text)
+}
+
+func (this *tomlParser) peek() byte {
+	if this.eof() {
+		return 0
+	}
+	return this.text[this.pos]
+}
+
+// 跳过空白和注释，newline为true时也跳过换行
+func (this *tomlParser) skipBlank(newline bool) {
+	for !this.eof() {
+		switch c := this.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			this.pos++
+		case c == '\n' && newline:
+			this.pos++
+			this.line++
+		case c == '#':
+			for !this.eof() && this.peek() != '\n' {
+				this.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (this *tomlParser) endOfLine() error {
+	this.skipBlank(false)
+	if this.eof() {
+		return nil
+	}
+	if this.peek() != '\n' {
+		return this.errorf("unexpected [%c] after value", this.peek())
+	}
+	return nil
+}
+
+// [a.b]
+func (this *tomlParser) table() (map[string]interface{}, error) {
+	keys, err := this.keyPath()
+	if err != nil {
+		return nil, err
+	}
+	if this.peek() != ']' {
+		return nil, this.errorf("expect ]")
+	}
+	this.pos++
+	return this.descend(this.root, keys)
+}
+
+// [[a.b]]，在数组的末尾新增一个表
+func (this *tomlParser) arrayTable() (map[string]interface{}, error) {
+	keys, err := this.keyPath()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(this.text[this.pos:], "]]") {
+		return nil, this.errorf("expect ]]")
+	}
+	this.pos += 2
+
+	parent, err := this.descend(this.root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	var list []interface{}
+	switch v := parent[last].(type) {
+	case nil:
+	case []interface{}:
+		list = v
+	default:
+		return nil, this.errorf("[%s] is not an array of tables", strings.Join(keys, "."))
+	}
+	m := make(map[string]interface{})
+	parent[last] = append(list, m)
+	return m, nil
+}
+
+// 沿着key找到（或者创建）表，数组取最后一个元素
+func (this *tomlParser) descend(m map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for i, k := range keys {
+		switch v := m[k].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			m[k] = next
+			m = next
+		case map[string]interface{}:
+			m = v
+		case []interface{}:
+			if len(v) == 0 {
+				return nil, this.errorf("[%s] is not a table", strings.Join(keys[:i+1], "."))
+			}
+			next, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, this.errorf("[%s] is not a table", strings.Join(keys[:i+1], "."))
+			}
+			m = next
+		default:
+			return nil, this.errorf("[%s] is not a table", strings.Join(keys[:i+1], "."))
+		}
+	}
+	return m, nil
+}
+
+func (this *tomlParser) keyValue(m map[string]interface{}) error {
+	keys, err := this.keyPath()
+	if err != nil {
+		return err
+	}
+	if this.peek() != '=' {
+		return this.errorf("expect = after key")
+	}
+	this.pos++
+	this.skipBlank(false)
+	value, err := this.value()
+	if err != nil {
+		return err
+	}
+
+	parent, err := this.descend(m, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := parent[last]; ok {
+		return this.errorf("duplicate key [%s]", strings.Join(keys, "."))
+	}
+	parent[last] = value
+	return nil
+}
+
+// a.b."c d"
+func (this *tomlParser) keyPath() ([]string, error) {
+	var keys []string
+	for {
+		this.skipBlank(false)
+		var key string
+		switch c := this.peek(); {
+		case c == '"':
+			s, err := this.basicString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		case c == '\'':
+			s, err := this.literalString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := this.pos
+			for !this.eof() && isBareKey(this.peek()) {
+				this.pos++
+			}
+			if start == this.pos {
+				return nil, this.errorf("expect key")
+			}
+			key = this.text[start:this.pos]
+		}
+		keys = append(keys, key)
+
+		this.skipBlank(false)
+		if this.peek() != '.' {
+			return keys, nil
+		}
+		this.pos++
+	}
+}
+
+func isBareKey(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_'
+}
+
+func (this *tomlParser) value() (interface{}, error) {
+	switch c := this.peek(); {
+	case c == 0:
+		return nil, this.errorf("missing value")
+	case c == '"':
+		return this.basicString()
+	case c == '\'':
+		return this.literalString()
+	case c == '[':
+		return this.array()
+	case c == '{':
+		return this.inlineTable()
+	}
+
+	start := this.pos
+	for !this.eof() {
+		c := this.peek()
+		if c == ',' || c == ']' || c == '}' || c == '#' || c == '\n' || c == '\r' ||
+			(c == ' ' || c == '\t') && !isDateTime(this.text[start:this.pos], this.text[this.pos+1:]) {
+			break
+		}
+		this.pos++
+	}
+	token := this.text[start:this.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf", "-inf", "nan", "+nan", "-nan":
+		return nil, this.errorf("unsupported value [%s]", token)
+	}
+
+	number := strings.Replace(token, "_", "", -1)
+	if v, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseFloat(number, 64); err == nil {
+		return v, nil
+	}
+	if len(token) > 0 && token[0] >= '0' && token[0] <= '9' && strings.ContainsAny(token, "-:") {
+		// 日期时间
+		return token, nil
+	}
+	return nil, this.errorf("invalid value [%s]", token)
+}
+
+// 日期和时间之间可以用空格分隔，例如1979-05-27 07:32:00
+func isDateTime(date, rest string) bool {
+	return len(date) == 10 && date[4] == '-' && date[7] == '-' &&
+		len(rest) >= 3 && rest[0] >= '0' && rest[0] <= '9' && rest[2] == ':'
+}
+
+func (this *tomlParser) array() (interface{}, error) {
+	this.pos++
+	list := make([]interface{}, 0)
+	for {
+		this.skipBlank(true)
+		if this.peek() == ']' {
+			this.pos++
+			return list, nil
+		}
+		v, err := this.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+
+		this.skipBlank(true)
+		switch this.peek() {
+		case ',':
+			this.pos++
+		case ']':
+		default:
+			return nil, this.errorf("expect , or ] in array")
+		}
+	}
+}
+
+func (this *tomlParser) inlineTable() (interface{}, error) {
+	this.pos++
+	m := make(map[string]interface{})
+	this.skipBlank(false)
+	if this.peek() == '}' {
+		this.pos++
+		return m, nil
+	}
+	for {
+		if err := this.keyValue(m); err != nil {
+			return nil, err
+		}
+		this.skipBlank(false)
+		switch this.peek() {
+		case ',':
+			this.pos++
+		case '}':
+			this.pos++
+			return m, nil
+		default:
+			return nil, this.errorf("expect , or } in inline table")
+		}
+	}
+}
+
+func (this *tomlParser) literalString() (string, error) {
+	if strings.HasPrefix(this.text[this.pos:], "'''") {
+		this.pos += 3
+		end := strings.Index(this.text[this.pos:], "'''")
+		if end < 0 {
+			return "", this.errorf("unterminated string")
+		}
+		s := strings.TrimPrefix(this.text[this.pos:this.pos+end], "\n")
+		this.line += strings.Count(this.text[this.pos:this.pos+end], "\n")
+		this.pos += end + 3
+		return s, nil
+	}
+
+	this.pos++
+	end := strings.IndexAny(this.text[this.pos:], "'\n")
+	if end < 0 || this.text[this.pos+end] != '\'' {
+		return "", this.errorf("unterminated string")
+	}
+	s := this.text[this.pos : this.pos+end]
+	this.pos += end + 1
+	return s, nil
+}
+
+func (this *tomlParser) basicString() (string, error) {
+	multi := strings.HasPrefix(this.text[this.pos:], `"""`)
+	if multi {
+		this.pos += 3
+		if this.peek() == '\n' {
+			this.pos++
+			this.line++
+		}
+	} else {
+		this.pos++
+	}
+
+	var buf strings.Builder
+	for {
+		if this.eof() {
+			return "", this.errorf("unterminated string")
+		}
+		c := this.peek()
+		switch {
+		case multi && strings.HasPrefix(this.text[this.pos:], `"""`):
+			this.pos += 3
+			return buf.String(), nil
+		case !multi && c == '"':
+			this.pos++
+			return buf.String(), nil
+		case !multi && c == '\n':
+			return "", this.errorf("unterminated string")
+		case c == '\\':
+			if err := this.escape(&buf, multi); err != nil {
+				return "", err
+			}
+		default:
+			if c == '\n' {
+				this.line++
+			}
+			buf.WriteByte(c)
+			this.pos++
+		}
+	}
+}
+
+func (this *tomlParser) escape(buf *strings.Builder, multi bool) error {
+	this.pos++
+	if this.eof() {
+		return this.errorf("unterminated string")
+	}
+	c := this.peek()
+	this.pos++
+	switch c {
+	case 'b':
+		buf.WriteByte('\b')
+	case 't':
+		buf.WriteByte('\t')
+	case 'n':
+		buf.WriteByte('\n')
+	case 'f':
+		buf.WriteByte('\f')
+	case 'r':
+		buf.WriteByte('\r')
+	case '"':
+		buf.WriteByte('"')
+	case '\\':
+		buf.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if this.pos+n > len(this.text) {
+			return this.errorf("invalid unicode escape")
+		}
+		code, err := strconv.ParseUint(this.text[this.pos:this.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return this.errorf("invalid unicode escape")
+		}
+		buf.WriteRune(rune(code))
+		this.pos += n
+	case ' ', '\t', '\r', '\n':
+		// 多行字符串中行尾的\去掉换行以及下一行开头的空白
+		if !multi {
+			return this.errorf("invalid escape")
+		}
+		this.pos--
+		for !this.eof() && strings.IndexByte(" \t\r\n", this.peek()) >= 0 {
+			if this.peek() == '\n' {
+				this.line++
+			}
+			this.pos++
+		}
+	default:
+		return this.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
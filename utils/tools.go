@@ -1,18 +1,145 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-func JsonConfToStruct(path string, obj interface{}) error {
+// 按照扩展名解析配置文件：.yaml/.yml、.toml，其他按照JSON；解析之后替换字符串值中的${ENV}，
+// 变量的值不会改变文件的结构，注释中的引用也不会报错
+func ConfToStruct(path string, obj interface{}) error {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	if err := json.Unmarshal(file, obj); err != nil {
+	var tree interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		tree, err = ParseYAML(string(file))
+	case ".toml":
+		tree, err = ParseTOML(string(file))
+	default:
+		tree, err = parseJSON(file)
+	}
+	if err != nil {
 		return err
 	}
+	if tree, err = interpolateTree(tree); err != nil {
+		return err
+	}
+
+	// 转成JSON，沿用json标签以及类型错误的路径
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return JsonToStruct(data, obj)
+}
+
+// 解析为树，数字保留原样（json.Number），语法错误带行列号
+func parseJSON(data []byte) (interface{}, error) {
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, jsonError(data, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// 替换树中所有字符串值的${ENV}，key不替换，错误带上JSON路径
+func interpolateTree(tree interface{}) (interface{}, error) {
+	v := NewValidator()
+	tree = interpolateValue(v, "", tree)
+	return tree, v.Err()
+}
+
+func interpolateValue(v *Validator, path string, tree interface{}) interface{} {
+	switch value := tree.(type) {
+	case string:
+		s, err := Interpolate(value)
+		if err != nil {
+			v.Errorf(path, "%s", err.Error())
+		}
+		return s
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = interpolateValue(v, JoinPath(path, k), item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = interpolateValue(v, IndexPath(path, i), item)
+		}
+	}
+	return tree
+}
+
+func JsonToStruct(data []byte, obj interface{}) error {
+	if err := json.Unmarshal(data, obj); err != nil {
+		return jsonError(data, err)
+	}
 	return nil
 }
+
+// 配置中的密钥字段（JSON的key），输出到日志时隐藏
+var secretKeys = map[string]bool{
+	"token":       true,
+	"admin_token": true,
+}
+
+// 用于日志输出的配置，隐藏所有非空的密钥字段
+func RedactConfig(conf interface{}) json.RawMessage {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil
+	}
+	if data, err = json.Marshal(redactValue(tree)); err != nil {
+		return nil
+	}
+	return data
+}
+
+func redactValue(tree interface{}) interface{} {
+	switch value := tree.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if s, ok := item.(string); ok && secretKeys[k] && s != "" {
+				value[k] = "******"
+				continue
+			}
+			value[k] = redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+	}
+	return tree
+}
+
+// 解析host:port，用于命令行参数
+func SplitHostPort(addr string) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 {
+		return "", 0, fmt.Errorf("invalid port [%s]", port)
+	}
+	return host, uint16(n), nil
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testConf struct {
+	Token    string   `json:"token"`
+	Port     uint16   `json:"port"`
+	Size     int64    `json:"size"`
+	Hosts    []string `json:"hosts"`
+	Networks []struct {
+		Topic string `json:"topic"`
+	} `json:"networks"`
+}
+
+func loadTestConf(t *testing.T, name, text string) (*testConf, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &testConf{}
+	return conf, ConfToStruct(path, conf)
+}
+
+func TestConfToStructInterpolate(t *testing.T) {
+	t.Setenv("TEST_TOKEN", `ab #cd "ef" $HOME`)
+	t.Setenv("TEST_TOPIC", "mysql")
+
+	files := map[string]string{
+		"conf.yaml": `
+# ${UNDEFINED_IN_COMMENT}
+token: ${TEST_TOKEN}
+port: 40001
+size: 9007199254740993
+hosts: [a, "${TEST_TOPIC:-x}.local"]
+networks:
+  - topic: ${TEST_TOPIC}
+`,
+		"conf.toml": `
+# ${UNDEFINED_IN_COMMENT}
+token = "${TEST_TOKEN}"
+port = 40001
+size = 9007199254740993
+hosts = ["a", "${TEST_TOPIC:-x}.local"]
+
+[[networks]]
+topic = "${TEST_TOPIC}"
+`,
+		"conf.json": `{
+	"token": "${TEST_TOKEN}",
+	"port": 40001,
+	"size": 9007199254740993,
+	"hosts": ["a", "${TEST_TOPIC:-x}.local"],
+	"networks": [{"topic": "${TEST_TOPIC}"}]
+}`,
+	}
+	for name, text := range files {
+		conf, err := loadTestConf(t, name, text)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if conf.Token != `ab #cd "ef" $HOME` {
+			t.Errorf("%s: token = %q", name, conf.Token)
+		}
+		if conf.Port != 40001 || conf.Size != 9007199254740993 {
+			t.Errorf("%s: port = %d, size = %d", name, conf.Port, conf.Size)
+		}
+		if len(conf.Hosts) != 2 || conf.Hosts[1] != "mysql.local" {
+			t.Errorf("%s: hosts = %v", name, conf.Hosts)
+		}
+		if len(conf.Networks) != 1 || conf.Networks[0].Topic != "mysql" {
+			t.Errorf("%s: networks = %v", name, conf.Networks)
+		}
+	}
+}
+
+func TestConfToStructErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		text   string
+		expect string
+	}{
+		{"undefined.yaml", "networks:\n  - topic: ${TEST_UNDEFINED}\n", "networks[0].topic: undefined environment variable TEST_UNDEFINED"},
+		{"undefined.json", `{"token": "${TEST_UNDEFINED"}`, "token: unterminated ${"},
+		{"type.json", `{"networks": [{"topic": 1}]}`, "networks[0].topic: expect string"},
+		{"type.yaml", "port: abc\n", "port: expect uint16"},
+		{"syntax.json", "{\n\"port\": 1,\n}", "line 3 column 2"},
+		{"trailing.json", `{"port": 1} {}`, "invalid"},
+	}
+	for _, c := range cases {
+		_, err := loadTestConf(t, c.name, c.text)
+		if err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Errorf("%s: got %v, expect %q", c.name, err, c.expect)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("TEST_A", "a")
+	t.Setenv("TEST_EMPTY", "")
+	cases := []struct {
+		text   string
+		expect string
+		err    bool
+	}{
+		{"${TEST_A}", "a", false},
+		{"x${TEST_A}y${TEST_A}", "xaya", false},
+		{"${TEST_EMPTY}", "", false},
+		{"${TEST_EMPTY:-d}", "d", false},
+		{"${TEST_UNDEFINED:-d}", "d", false},
+		{"$$TEST_A", "$TEST_A", false},
+		{"$TEST_A", "$TEST_A", false},
+		{"cost $", "cost $", false},
+		{"${TEST_UNDEFINED}", "", true},
+		{"${TEST_A", "", true},
+		{"${}", "", true},
+	}
+	for _, c := range cases {
+		got, err := Interpolate(c.text)
+		if (err != nil) != c.err || got != c.expect {
+			t.Errorf("Interpolate(%q) = %q, %v", c.text, got, err)
+		}
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	type network struct {
+		Token string `json:"token"`
+		Topic string `json:"topic"`
+	}
+	conf := struct {
+		Token      string     `json:"token"`
+		AdminToken string     `json:"admin_token"`
+		Networks   []*network `json:"networks"`
+	}{
+		Token:    "global-secret",
+		Networks: []*network{{Token: "net-secret", Topic: "db"}, {Topic: "web"}},
+	}
+	got := string(RedactConfig(&conf))
+	if strings.Contains(got, "secret") {
+		t.Fatalf("secret not redacted: %s", got)
+	}
+	expect := `{"admin_token":"","networks":[{"token":"******","topic":"db"},{"token":"","topic":"web"}],"token":"******"}`
+	if got != expect {
+		t.Fatalf("got %s, expect %s", got, expect)
+	}
+}
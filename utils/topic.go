@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"strings"
+)
+
+// 参考rabbitMQ的Topic语义，单词之间用.分隔，*匹配一个单词，#匹配零个或多个单词
+func IsTopicPattern(topic string) bool {
+	for _, w := range strings.Split(topic, ".") {
+		if w == "*" || w == "#" {
+			return true
+		}
+	}
+	return false
+}
+
+func TopicMatch(pattern, topic string) bool {
+	return matchWords(collapseHash(strings.Split(pattern, ".")), strings.Split(topic, "."))
+}
+
+// 连续的#和单个#等价，合并之后减少匹配的状态
+func collapseHash(pattern []string) []string {
+	list := make([]string, 0, len(pattern))
+	for _, w := range pattern {
+		if w == "#" && len(list) > 0 && list[len(list)-1] == "#" {
+			continue
+		}
+		list = append(list, w)
+	}
+	return list
+}
+
+// 动态规划，next[j]表示pattern[i+1:]能否匹配words[j:]，从后往前计算，复杂度O(len(pattern)*len(words))
+func matchWords(pattern, words []string) bool {
+	next := make([]bool, len(words)+1)
+	cur := make([]bool, len(words)+1)
+	next[len(words)] = true
+	for i := len(pattern) - 1; i >= 0; i-- {
+		switch pattern[i] {
+		case "#":
+			// 吃掉零个单词，或者吃掉一个单词之后继续由#匹配
+			cur[len(words)] = next[len(words)]
+			for j := len(words) - 1; j >= 0; j-- {
+				cur[j] = next[j] || cur[j+1]
+			}
+		default:
+			cur[len(words)] = false
+			for j := len(words) - 1; j >= 0; j-- {
+				cur[j] = (pattern[i] == "*" || pattern[i] == words[j]) && next[j+1]
+			}
+		}
+		next, cur = cur, next
+	}
+	return next[0]
+}
+
+// 两个pattern是否存在同时匹配的Topic，用于判断out注册的通配Topic会收到哪些配置Topic的请求
+func TopicOverlap(a, b string) bool {
+	pa := collapseHash(strings.Split(a, "."))
+	pb := collapseHash(strings.Split(b, "."))
+
+	// can[i][j]表示pa[i:]和pb[j:]能否匹配同一个单词序列，从后往前计算
+	can := make([][]bool, len(pa)+1)
+	for i := range can {
+		can[i] = make([]bool, len(pb)+1)
+	}
+	for i := len(pa); i >= 0; i-- {
+		for j := len(pb); j >= 0; j-- {
+			switch {
+			case i == len(pa) && j == len(pb):
+				can[i][j] = true
+			case i < len(pa) && pa[i] == "#":
+				// #匹配零个单词，或者吃掉对方的一个单词（对方的#同样可以看作被吃掉）
+				can[i][j] = can[i+1][j] || (j < len(pb) && can[i][j+1])
+			case j < len(pb) && pb[j] == "#":
+				can[i][j] = can[i][j+1] || (i < len(pa) && can[i+1][j])
+			case i < len(pa) && j < len(pb):
+				can[i][j] = (pa[i] == "*" || pb[j] == "*" || pa[i] == pb[j]) && can[i+1][j+1]
+			}
+		}
+	}
+	return can[0][0]
+}
+
+// 比较两个pattern哪个更具体：字面单词多的优先，其次#少的，再次*少的，最后按字符串排序保证结果确定
+func TopicMoreSpecific(a, b string) bool {
+	ca := countWords(a)
+	cb := countWords(b)
+	if ca.literal != cb.literal {
+		return ca.literal > cb.literal
+	}
+	if ca.hash != cb.hash {
+		return ca.hash < cb.hash
+	}
+	if ca.star != cb.star {
+		return ca.star < cb.star
+	}
+	return a < b
+}
+
+type wordCount struct {
+	literal int
+	star    int
+	hash    int
+}
+
+func countWords(pattern string) (c wordCount) {
+	for _, w := range strings.Split(pattern, ".") {
+		switch w {
+		case "*":
+			c.star++
+		case "#":
+			c.hash++
+		default:
+			c.literal++
+		}
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"db.mysql", "db.mysql", true},
+		{"db.mysql", "db.pg", false},
+		{"db.*", "db.mysql", true},
+		{"db.*", "db", false},
+		{"db.*", "db.mysql.primary", false},
+		{"db.*.primary", "db.mysql.primary", true},
+		{"db.*.primary", "db.mysql.replica", false},
+		{"db.#", "db", true},
+		{"db.#", "db.mysql.primary", true},
+		{"#.primary", "primary", true},
+		{"#.primary", "db.mysql.primary", true},
+		{"#.primary", "db.mysql.replica", false},
+		{"db.#.primary", "db.primary", true},
+		{"db.#.primary", "db.a.b.c.primary", true},
+		{"db.#.#.primary", "db.primary", true},
+		{"db.#.*", "db", false},
+		{"db.#.*", "db.a", true},
+		{"a.#.b.#.c", "a.x.b.y.z.c", true},
+		{"a.#.b.#.c", "a.x.c.y.b", false},
+		{"*.*", "a.b", true},
+		{"*.*", "a", false},
+	}
+	for _, c := range cases {
+		if got := TopicMatch(c.pattern, c.topic); got != c.match {
+			t.Errorf("TopicMatch(%s, %s) = %v, expect %v", c.pattern, c.topic, got, c.match)
+		}
+	}
+}
+
+// 多个#的pattern不能因为回溯变成指数复杂度
+func TestTopicMatchManyHashes(t *testing.T) {
+	pattern := strings.Repeat("#.", 12) + "x"
+	words := make([]string, 40)
+	for i := range words {
+		words[i] = "w"
+	}
+	topic := strings.Join(words, ".")
+
+	start := time.Now()
+	if TopicMatch(pattern, topic) {
+		t.Fatal("should not match")
+	}
+	if !TopicMatch(pattern, topic+".x") {
+		t.Fatal("should match")
+	}
+	pattern = strings.Repeat("#.a.", 60) + "x"
+	TopicMatch(pattern, strings.Repeat("a.", 120)+"y")
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("too slow: %s", d)
+	}
+}
+
+func TestTopicMoreSpecific(t *testing.T) {
+	cases := []struct {
+		a, b string
+	}{
+		{"db.*.primary", "db.#"},
+		{"db.mysql.*", "db.*.*"},
+		{"db.*", "db.#"},
+		{"a.*", "b.*"},
+	}
+	for _, c := range cases {
+		if !TopicMoreSpecific(c.a, c.b) || TopicMoreSpecific(c.b, c.a) {
+			t.Errorf("%s should be more specific than %s", c.a, c.b)
+		}
+	}
+}
+
+func TestTopicOverlap(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect bool
+	}{
+		{"#.secret", "secret", true},
+		{"#.secret", "a.b.secret", true},
+		{"#.secret", "secret.a", false},
+		{"*.db", "secret", false},
+		{"*.db", "app.db", true},
+		{"*.db", "app.*", true},
+		{"*.db", "#.cache", false},
+		{"a.#", "#.b", true},
+		{"a.#.c", "a.b", false},
+		{"a.#.c", "*.*.*.c", true},
+		{"a.*", "a.#.b.#", true},
+		{"a.*", "a.b.c", false},
+	}
+	for _, c := range cases {
+		if got := TopicOverlap(c.a, c.b); got != c.expect {
+			t.Errorf("TopicOverlap(%s, %s) = %v, expect %v", c.a, c.b, got, c.expect)
+		}
+		if got := TopicOverlap(c.b, c.a); got != c.expect {
+			t.Errorf("TopicOverlap(%s, %s) = %v, expect %v", c.b, c.a, got, c.expect)
+		}
+	}
+}
+
+func TestCheckTopic(t *testing.T) {
+	cases := []struct {
+		topic   string
+		pattern bool
+		ok      bool
+	}{
+		{"db.mysql", false, true},
+		{"db-1.my_sql", false, true},
+		{"db.*", false, false},
+		{"db.*", true, true},
+		{"db.#", true, true},
+		{"#", true, false},
+		{"*.#", true, false},
+		{"", true, false},
+		{"db..mysql", false, false},
+		{"db.mysql.", false, false},
+		{"db/mysql", false, false},
+		{"db.my*", true, false},
+		{strings.Repeat("a", 256), false, false},
+	}
+	for _, c := range cases {
+		if err := CheckTopic(c.topic, c.pattern); (err == nil) != c.ok {
+			t.Errorf("CheckTopic(%s, %v) = %v, expect ok %v", c.topic, c.pattern, err, c.ok)
+		}
+	}
+}
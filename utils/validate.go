@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/qjw/proxy/logs"
+)
+
+const maxTopicLen = 255
+
+// 配置中的一处错误，Path为JSON路径，例如networks[0].port
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+// 校验配置时收集到的所有错误，一次全部报告
+type ConfigErrors []FieldError
+
+func (this ConfigErrors) Error() string {
+	lines := make([]string, 0, len(this)+1)
+	lines = append(lines, fmt.Sprintf("invalid config, %d error(s):", len(this)))
+	for _, v := range this {
+		lines = append(lines, fmt.Sprintf("  %s: %s", v.Path, v.Message))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type Validator struct {
+	errs ConfigErrors
+}
+
+func NewValidator() *Validator {
+	return &Validator{}
+}
+
+func (this *Validator) Errorf(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "$"
+	}
+	this.errs = append(this.errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// 没有错误时返回nil
+func (this *Validator) Err() error {
+	if len(this.errs) == 0 {
+		return nil
+	}
+	return this.errs
+}
+
+// 拼接JSON路径
+func JoinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func IndexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// 检查所有带binding:"required"标签的字段不为零值，递归检查嵌套的结构体和数组
+func (this *Validator) Required(path string, obj interface{}) {
+	this.required(path, reflect.ValueOf(obj))
+}
+
+func (this *Validator) required(path string, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			this.required(path, v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			this.required(IndexPath(path, i), v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fp := JoinPath(path, name)
+			if strings.Contains(f.Tag.Get("binding"), "required") && v.Field(i).IsZero() {
+				this.Errorf(fp, "required")
+				continue
+			}
+			this.required(fp, v.Field(i))
+		}
+	}
+}
+
+// 合法的IP或者域名，为空时由Required检查
+func (this *Validator) Host(path, host string) {
+	if host != "" && !ValidHost(host) {
+		this.Errorf(path, "invalid host [%s]", host)
+	}
+}
+
+// host:port格式的监听地址，host可以为空（所有地址）
+func (this *Validator) Addr(path, addr string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		this.Errorf(path, "invalid address [%s], expect host:port", addr)
+		return
+	}
+	if host != "" && !ValidHost(host) {
+		this.Errorf(path, "invalid host [%s]", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		this.Errorf(path, "invalid port [%s]", port)
+	}
+}
+
+// pattern为true时允许*和#通配符
+func (this *Validator) Topic(path, topic string, pattern bool) {
+	if err := CheckTopic(topic, pattern); err != nil {
+		this.Errorf(path, "%s", err.Error())
+	}
+}
+
+// 取值必须是allowed之一
+func (this *Validator) OneOf(path, value string, allowed ...string) {
+	for _, v := range allowed {
+		if v == value {
+			return
+		}
+	}
+	this.Errorf(path, "invalid value [%s], expect one of %s", value, strings.Join(allowed, "/"))
+}
+
+// 证书和私钥必须同时配置；server为true时必须配置
+func (this *Validator) TLS(path string, conf *TLSConfig, server bool) {
+	if conf == nil {
+		return
+	}
+	if (conf.Cert == "") != (conf.Key == "") {
+		this.Errorf(path, "cert and key must be configured together")
+	} else if server && conf.Cert == "" {
+		this.Errorf(JoinPath(path, "cert"), "required")
+	}
+}
+
+func ValidHost(host string) bool {
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return true
+	}
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 转发的目标地址，host:port格式，host和port都不能为空
+func CheckTarget(addr string) error {
+	host, _, err := SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid target [%s]: %s", addr, err.Error())
+	}
+	if !ValidHost(host) {
+		return fmt.Errorf("invalid target [%s]: invalid host", addr)
+	}
+	return nil
+}
+
+// Topic由.分隔的单词组成，单词只能包含字母、数字、-和_；pattern为true时单词还可以是*或者#，但至少有一个字面单词
+func CheckTopic(topic string, pattern bool) error {
+	if topic == "" {
+		return fmt.Errorf("topic is empty")
+	}
+	if len(topic) > maxTopicLen {
+		return fmt.Errorf("topic is longer than %d", maxTopicLen)
+	}
+	literal := false
+	for _, w := range strings.Split(topic, ".") {
+		if w == "" {
+			return fmt.Errorf("invalid topic [%s], empty word", topic)
+		}
+		if w == "*" || w == "#" {
+			if !pattern {
+				return fmt.Errorf("invalid topic [%s], wildcard not allowed", topic)
+			}
+			continue
+		}
+		literal = true
+		for _, c := range w {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid topic [%s], unexpected character %q", topic, c)
+			}
+		}
+	}
+	// 只有通配符的pattern（例如#）会接管所有没有out的Topic
+	if !literal {
+		return fmt.Errorf("invalid topic [%s], pattern without literal word", topic)
+	}
+	return nil
+}
+
+// 把JSON解析的错误转换为带路径或者行列号的错误
+func jsonError(data []byte, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return ConfigErrors{{
+			Path:    fieldPath(typeErr.Field),
+			Message: fmt.Sprintf("expect %s, got %s", typeErr.Type, typeErr.Value),
+		}}
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, col := position(data, syntaxErr.Offset)
+		return fmt.Errorf("invalid json at line %d column %d: %s", line, col, syntaxErr)
+	}
+	return err
+}
+
+// encoding/json的路径中数组下标也用.分隔，例如networks.0.port
+func fieldPath(field string) string {
+	path := ""
+	for _, v := range strings.Split(field, ".") {
+		if i, err := strconv.Atoi(v); err == nil {
+			path = IndexPath(path, i)
+		} else {
+			path = JoinPath(path, v)
+		}
+	}
+	return path
+}
+
+func position(data []byte, offset int64) (int, int) {
+	line, col := 1, 1
+	for i := int64(0); i < offset && i < int64(len(data)); i++ {
+		if data[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// 日志配置
+func (this *Validator) Log(path string, conf *logs.Config) {
+	if conf == nil {
+		return
+	}
+	if conf.Level != "" {
+		this.OneOf(JoinPath(path, "level"), strings.ToLower(conf.Level), "debug", "info", "warn", "error")
+	}
+	if conf.Format != "" {
+		this.OneOf(JoinPath(path, "format"), conf.Format, logs.FormatText, logs.FormatJSON)
+	}
+	if conf.MaxSize < 0 {
+		this.Errorf(JoinPath(path, "max_size"), "must not be negative")
+	}
+	if conf.MaxBackups < 0 {
+		this.Errorf(JoinPath(path, "max_backups"), "must not be negative")
+	}
+}
+
+// 访问日志配置
+func (this *Validator) Access(path string, conf *logs.AccessConfig) {
+	if conf == nil {
+		return
+	}
+	if conf.Format != "" {
+		this.OneOf(JoinPath(path, "format"), conf.Format, logs.FormatJSON, logs.FormatText)
+	}
+	if conf.MaxSize < 0 {
+		this.Errorf(JoinPath(path, "max_size"), "must not be negative")
+	}
+	if conf.MaxBackups < 0 {
+		this.Errorf(JoinPath(path, "max_backups"), "must not be negative")
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 错误的JSON路径，排序之后便于比较
+func errorPaths(t *testing.T, err error) []string {
+	t.Helper()
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expect ConfigErrors, got %v", err)
+	}
+	paths := make([]string, 0, len(errs))
+	for _, v := range errs {
+		paths = append(paths, v.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestValidatorRequired(t *testing.T) {
+	type item struct {
+		Name string `json:"name" binding:"required"`
+		Note string `json:"note"`
+	}
+	type conf struct {
+		Host  string  `json:"host" binding:"required"`
+		Items []*item `json:"items"`
+		Inner *item   `json:"inner"`
+		Skip  *item   `json:"-"`
+	}
+	v := NewValidator()
+	v.Required("", &conf{
+		Items: []*item{{Name: "a"}, {Note: "b"}, nil},
+		Inner: &item{},
+		Skip:  &item{},
+	})
+	expect := []string{"host", "inner.name", "items[1].name"}
+	if got := errorPaths(t, v.Err()); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %v, expect %v", got, expect)
+	}
+	if err := NewValidator().Err(); err != nil {
+		t.Fatalf("no error should return nil, got %v", err)
+	}
+}
+
+func TestValidatorFields(t *testing.T) {
+	v := NewValidator()
+	v.Host("host", "bad host")
+	v.Host("ok_host", "example.com")
+	v.Addr("addr", "127.0.0.1")
+	v.Addr("port", "127.0.0.1:70000")
+	v.Addr("ok_addr", ":8080")
+	v.Topic("topic", "db.*", false)
+	v.Topic("ok_topic", "db.*", true)
+	v.OneOf("mode", "udp", "tcp", "http")
+	v.TLS("tls", &TLSConfig{Cert: "a.pem"}, false)
+	v.TLS("server_tls", &TLSConfig{CA: "ca.pem"}, true)
+	v.Errorf("", "root")
+
+	expect := []string{"$", "addr", "host", "mode", "port", "server_tls.cert", "tls", "topic"}
+	if got := errorPaths(t, v.Err()); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %v, expect %v", got, expect)
+	}
+	msg := v.Err().Error()
+	if !strings.HasPrefix(msg, "invalid config, 8 error(s):") || !strings.Contains(msg, "  mode: invalid value [udp], expect one of tcp/http") {
+		t.Fatalf("unexpected message:\n%s", msg)
+	}
+}
+
+func TestJsonErrorPath(t *testing.T) {
+	var conf struct {
+		Networks []struct {
+			Port uint16 `json:"port"`
+		} `json:"networks"`
+	}
+	err := JsonToStruct([]byte(`{"networks": [{"port": 1}, {"port": "x"}]}`), &conf)
+	if got := errorPaths(t, err); !reflect.DeepEqual(got, []string{"networks[1].port"}) {
+		t.Fatalf("got %v", got)
+	}
+
+	err = JsonToStruct([]byte("{\n  \"networks\": [\n    {\"port\": 1,}\n  ]\n}"), &conf)
+	if err == nil || !strings.Contains(err.Error(), "line 3 column") {
+		t.Fatalf("syntax error should carry the position, got %v", err)
+	}
+}
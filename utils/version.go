@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	FeatureAuth     string = "auth"     // 握手签名
+	FeatureCodec    string = "codec"    // 编码协商
+	FeatureLease    string = "lease"    // 注册租约/备用/权重
+	FeatureMux      string = "mux"      // 多路复用
+	FeatureCompress string = "compress" // 数据压缩
+	FeatureTarget   string = "target"   // 转发目标地址
+)
+
+// 本端支持的特性
+var Features = []string{
+	FeatureAuth,
+	FeatureCodec,
+	FeatureLease,
+	FeatureMux,
+	FeatureCompress,
+	FeatureTarget,
+}
+
+// 握手协商的结果
+type Capabilities struct {
+	Version  string   // 双方都支持的最高版本
+	Features []string // 双方都支持的特性
+}
+
+func (this *Capabilities) Has(feature string) bool {
+	if this == nil {
+		return false
+	}
+	for _, v := range this.Features {
+		if v == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// 比较点分格式的版本号，a<b返回-1，a==b返回0，a>b返回1
+func CompareVersion(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// 根据对端支持的版本范围和特性协商，min为空表示对端只支持max（旧版本）
+func Negotiate(min, max string, features []string) (*Capabilities, error) {
+	if max == "" {
+		return nil, fmt.Errorf("invalid version")
+	}
+	if min == "" {
+		min = max
+	}
+
+	low := MinVersion
+	if CompareVersion(min, low) > 0 {
+		low = min
+	}
+	high := Version
+	if CompareVersion(max, high) < 0 {
+		high = max
+	}
+	if CompareVersion(low, high) > 0 {
+		return nil, fmt.Errorf("invalid version [%s-%s|%s-%s]", min, max, MinVersion, Version)
+	}
+
+	caps := &Capabilities{
+		Version:  high,
+		Features: make([]string, 0),
+	}
+	for _, v := range features {
+		for _, f := range Features {
+			if v == f {
+				caps.Features = append(caps.Features, v)
+				break
+			}
+		}
+	}
+	return caps, nil
+}
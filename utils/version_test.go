@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersion(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect int
+	}{
+		{"0.1.0", "0.1.0", 0},
+		{"0.1", "0.1.0", 0},
+		{"0.0.9", "0.1.0", -1},
+		{"0.10.0", "0.9.0", 1},
+		{"1", "0.99.99", 1},
+	}
+	for _, c := range cases {
+		if got := CompareVersion(c.a, c.b); got != c.expect {
+			t.Errorf("CompareVersion(%s, %s) = %d, expect %d", c.a, c.b, got, c.expect)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		name     string
+		min, max string
+		features []string
+		version  string
+		expect   []string
+		fail     bool
+	}{
+		{"same range", MinVersion, Version, Features, Version, Features, false},
+		{"newer peer", MinVersion, "9.0.0", []string{FeatureAuth, "future"}, Version, []string{FeatureAuth}, false},
+		{"older peer", "", MinVersion, nil, MinVersion, []string{}, false},
+		{"overlap", "0.0.5", "0.0.8", []string{FeatureLease, FeatureMux}, "0.0.8", []string{FeatureLease, FeatureMux}, false},
+		{"too new", "9.0.0", "9.1.0", nil, "", nil, true},
+		{"too old", "", "0.0.0", nil, "", nil, true},
+		{"no version", "", "", nil, "", nil, true},
+	}
+	for _, c := range cases {
+		caps, err := Negotiate(c.min, c.max, c.features)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail, got %+v", c.name, caps)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if caps.Version != c.version || !reflect.DeepEqual(caps.Features, c.expect) {
+			t.Errorf("%s: got %s %v, expect %s %v", c.name, caps.Version, caps.Features, c.version, c.expect)
+		}
+	}
+
+	var none *Capabilities
+	if none.Has(FeatureAuth) {
+		t.Error("nil capabilities should have no feature")
+	}
+}
@@ -0,0 +1,340 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// YAML的一个子集，足够写配置文件：缩进的映射和数组、"- key: value"形式的数组元素、
+// 单行的[a, b]和{k: v}、单双引号字符串、数字、布尔、null以及#注释；
+// 不支持锚点、多文档和|、>多行字符串
+type yamlLine struct {
+	no      int // 行号，从1开始
+	indent  int
+	content string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// 解析为map[string]interface{}、[]interface{}以及标量组成的树
+func ParseYAML(text string) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(text, "\n") {
+		line := strings.TrimRight(stripYAMLComment(strings.TrimRight(raw, "\r")), " \t")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("yaml line %d: tab is not allowed in indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{no: i + 1, indent: len(line) - len(content), content: content})
+	}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+func (this *yamlParser) errorf(format string, args ...interface{}) error {
+	line := this.lines[len(this.lines)-1].no
+	if this.pos < len(this.lines) {
+		line = this.lines[this.pos].no
+	}
+	return fmt.Errorf("yaml line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func isSeqItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+func (this *yamlParser) block(indent int) (interface{}, error) {
+	if isSeqItem(this.lines[this.pos].content) {
+		return this.sequence(indent)
+	}
+	return this.mapping(indent)
+}
+
+func (this *yamlParser) sequence(indent int) (interface{}, error) {
+	list := make([]interface{}, 0)
+	for this.pos < len(this.lines) {
+		line := this.lines[this.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, this.errorf("unexpected indentation")
+		}
+		if !isSeqItem(line.content) {
+			break
+		}
+
+		rest := strings.TrimLeft(line.content[1:], " ")
+		switch {
+		case rest == "":
+			this.pos++
+			if this.pos < len(this.lines) && this.lines[this.pos].indent > indent {
+				v, err := this.block(this.lines[this.pos].indent)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			} else {
+				list = append(list, nil)
+			}
+		case yamlKeySep(rest) >= 0:
+			// "- key: value"，同一个元素后面的key和key对齐
+			this.lines[this.pos] = yamlLine{
+				no:      line.no,
+				indent:  line.indent + len(line.content) - len(rest),
+				content: rest,
+			}
+			v, err := this.mapping(this.lines[this.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		default:
+			v, err := yamlScalar(rest)
+			if err != nil {
+				return nil, this.errorf("%s", err.Error())
+			}
+			list = append(list, v)
+			this.pos++
+		}
+	}
+	return list, nil
+}
+
+func (this *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for this.pos < len(this.lines) {
+		line := this.lines[this.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, this.errorf("unexpected indentation")
+		}
+		if isSeqItem(line.content) {
+			break
+		}
+
+		sep := yamlKeySep(line.content)
+		if sep < 0 {
+			return nil, this.errorf("expect key: value")
+		}
+		key, err := yamlKey(strings.TrimSpace(line.content[:sep]))
+		if err != nil {
+			return nil, this.errorf("%s", err.Error())
+		}
+		if _, ok := m[key]; ok {
+			return nil, this.errorf("duplicate key [%s]", key)
+		}
+		value := strings.TrimSpace(line.content[sep+1:])
+		this.pos++
+
+		if value == "" {
+			var next *yamlLine
+			if this.pos < len(this.lines) {
+				next = &this.lines[this.pos]
+			}
+			switch {
+			case next != nil && next.indent > indent:
+				m[key], err = this.block(next.indent)
+			case next != nil && next.indent == indent && isSeqItem(next.content):
+				// 数组可以和父级的key对齐
+				m[key], err = this.sequence(indent)
+			default:
+				m[key] = nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if value[0] == '|' || value[0] == '>' || value[0] == '&' || value[0] == '*' {
+			return nil, fmt.Errorf("yaml line %d: unsupported syntax [%s]", line.no, value)
+		}
+		if m[key], err = yamlScalar(value); err != nil {
+			return nil, fmt.Errorf("yaml line %d: %s", line.no, err.Error())
+		}
+	}
+	return m, nil
+}
+
+// 去掉#注释，引号内的#除外
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// key和value之间的冒号位置（冒号后面是空格或者行尾），没有返回-1
+func yamlKeySep(s string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ':' && depth == 0 && (i+1 == len(s) || s[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+func yamlKey(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		v, err := yamlScalar(s)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v", v), nil
+	}
+	return s, nil
+}
+
+func yamlScalar(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, nil
+	case s[0] == '"':
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case s[0] == '[':
+		if s[len(s)-1] != ']' {
+			return nil, fmt.Errorf("unterminated flow sequence %s", s)
+		}
+		list := make([]interface{}, 0)
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			v, err := yamlScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case s[0] == '{':
+		if s[len(s)-1] != '}' {
+			return nil, fmt.Errorf("unterminated flow mapping %s", s)
+		}
+		m := make(map[string]interface{})
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			sep := yamlKeySep(item)
+			if sep < 0 {
+				return nil, fmt.Errorf("expect key: value in %s", s)
+			}
+			key, err := yamlKey(strings.TrimSpace(item[:sep]))
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = yamlScalar(strings.TrimSpace(item[sep+1:])); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	switch s {
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v, nil
+	}
+	if strings.ContainsAny(s, ".eE") {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v, nil
+		}
+	}
+	return s, nil
+}
+
+// 按照顶层的逗号切分[a, b]或者{k: v}的内容
+func splitFlow(s string) []string {
+	var items []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}